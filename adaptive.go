@@ -0,0 +1,198 @@
+package messagingapi
+
+import (
+	"errors"
+	"strings"
+)
+
+// Decisions reported by CreateAdaptive
+const (
+	// The MMS was sent unchanged
+	AdaptiveDecisionMMS = "mms"
+	// The MMS images were adapted to the handset's screen before sending
+	AdaptiveDecisionAdaptedMMS = "adapted_mms"
+	// An SMS was sent in place of the MMS
+	AdaptiveDecisionFallbackSMS = "fallback_sms"
+	// Nothing was sent
+	AdaptiveDecisionRefused = "refused"
+)
+
+// AdaptiveOptions configures CreateAdaptive
+type AdaptiveOptions struct {
+	// FallbackText is the SMS sent in place of the MMS, defaults to the
+	// text content of the slides
+	FallbackText string
+	// FallbackLink is appended to the fallback SMS, for example a link
+	// to a web version of the MMS (optional)
+	FallbackLink string
+	// FallbackNetwork is the network the fallback SMS is sent on,
+	// defaults to the network of the MMS
+	FallbackNetwork string
+	// DisableFallback refuses the send instead of falling back to SMS
+	DisableFallback bool
+	// TreatUnknownScreenAsSMS falls back to SMS when the scrub doesn't
+	// report the handset's screen size. By default the MMS is sent with
+	// its images only fitted to Images and the size limit
+	TreatUnknownScreenAsSMS bool
+	// Images configures how images are adapted, the maximum dimensions
+	// default to the handset's screen size
+	Images MMSImageOptions
+}
+
+// AdaptiveResult reports what CreateAdaptive decided and sent
+type AdaptiveResult struct {
+	// Decision is one of AdaptiveDecision*
+	Decision string
+	// Reason describes why the decision was made
+	Reason string
+	// Scrub is the handset information the decision was based on
+	Scrub ScrubResult
+	// Message is the message that was sent, if any
+	Message NewMessage
+	// Result is the result of the Create call, if any
+	Result APIResult
+}
+
+// CreateAdaptive scrubs the recipient of an MMS before sending it. The
+// send is refused when the scrub doesn't allow sending, images are fitted
+// to the handset's screen when the scrub reports it and shrunk to the size
+// limit, and messages that can't be made to fit get an SMS instead. See
+// AdaptiveOptions.TreatUnknownScreenAsSMS for handsets without a known
+// screen size. Configure a ScrubCache to avoid scrubbing on every send
+func (api *MessagingAPI) CreateAdaptive(message NewMessage, options AdaptiveOptions) (AdaptiveResult, error) {
+	result := AdaptiveResult{}
+
+	if message.Action != APIActionTypesSubmitMMS {
+		return result, errors.New("CreateAdaptive requires the SubmitMMS action")
+	}
+	data, ok := message.Data.(SubmitMMSMessageData)
+	if !ok {
+		return result, errors.New("CreateAdaptive requires Data to be of type SubmitMMSMessageData")
+	}
+	// The size limit is checked by Create once the images were adapted
+	err := message.validateFields(api.config, false)
+	if err != nil {
+		return result, err
+	}
+
+	scrubResult, err := api.GetMSISDNScrub(data.MSISDN[0])
+	if err != nil {
+		return result, err
+	}
+	if scrubResult.StatusCode != APIResultStatusesOk {
+		result.Decision = AdaptiveDecisionRefused
+		result.Reason = "Unable to scrub recipient: " + scrubResult.StatusDescription
+		return result, nil
+	}
+	scrub := scrubResult.ScrubResult
+	result.Scrub = scrub
+
+	if !scrub.AllowSend {
+		result.Decision = AdaptiveDecisionRefused
+		result.Reason = "Scrub does not allow sending to this recipient"
+		if scrub.Error != "" {
+			result.Reason += ": " + scrub.Error
+		}
+		return result, nil
+	}
+
+	if !scrub.ScreenSize.Known() && options.TreatUnknownScreenAsSMS {
+		if options.DisableFallback {
+			result.Decision = AdaptiveDecisionRefused
+			result.Reason = "Handset screen size is unknown and fallback is disabled"
+			return result, nil
+		}
+		result.Decision = AdaptiveDecisionFallbackSMS
+		result.Reason = "Handset screen size is unknown"
+		result.Message = fallbackSMS(message, data, options)
+		result.Result, err = api.Create(result.Message)
+		return result, err
+	}
+
+	adapted := copyMMSMessageData(data)
+	imageOptions := options.Images
+	if scrub.ScreenSize.Known() {
+		screen := MMSImageOptionsForScreen(scrub.ScreenSize)
+		if imageOptions.MaxWidth == 0 {
+			imageOptions.MaxWidth = screen.MaxWidth
+		}
+		if imageOptions.MaxHeight == 0 {
+			imageOptions.MaxHeight = screen.MaxHeight
+		}
+	}
+	if imageOptions.SizeLimit <= 0 {
+		imageOptions.SizeLimit = api.config.MMSSizeLimit(data.Network)
+	}
+	err = adapted.OptimizeImages(imageOptions)
+	if err != nil {
+		if options.DisableFallback || !api.MMSSizeReport(adapted).Exceeded() {
+			return result, err
+		}
+		result.Decision = AdaptiveDecisionFallbackSMS
+		result.Reason = "MMS can not be made to fit the size limit: " + err.Error()
+		result.Message = fallbackSMS(message, data, options)
+		result.Result, err = api.Create(result.Message)
+		return result, err
+	}
+
+	result.Decision = AdaptiveDecisionMMS
+	result.Reason = "Handset can receive the MMS as is"
+	if adapted.SizeReport().Total != data.SizeReport().Total {
+		result.Decision = AdaptiveDecisionAdaptedMMS
+		result.Reason = "Images adapted to the size limit"
+		if scrub.ScreenSize.Known() {
+			result.Reason = "Images adapted to a " + scrub.ScreenSize.String() + " screen"
+		}
+	}
+	message.Data = adapted
+	result.Message = message
+	result.Result, err = api.Create(message)
+	return result, err
+}
+
+// fallbackSMS builds the SMS sent in place of an MMS
+func fallbackSMS(message NewMessage, data SubmitMMSMessageData, options AdaptiveOptions) NewMessage {
+	text := options.FallbackText
+	if text == "" {
+		var parts []string
+		for _, slide := range data.Slides {
+			for _, content := range slide.Content {
+				if content.Type == MMSContentTypeText {
+					parts = append(parts, content.Data)
+				}
+			}
+		}
+		text = strings.Join(parts, "\n")
+	}
+	if text == "" {
+		text = data.Subject
+	}
+	if options.FallbackLink != "" {
+		text = strings.TrimSpace(text + " " + options.FallbackLink)
+	}
+
+	network := options.FallbackNetwork
+	if network == "" {
+		network = data.Network
+	}
+
+	message.Action = APIActionTypesSubmitSMS
+	message.Data = SubmitSMSMessageData{
+		Network: network,
+		MSISDN:  data.MSISDN,
+		Message: text,
+	}
+	return message
+}
+
+// copyMMSMessageData copies the slides so they can be changed without
+// changing the caller's message
+func copyMMSMessageData(data SubmitMMSMessageData) SubmitMMSMessageData {
+	slides := make([]MMSSlide, len(data.Slides))
+	for i, slide := range data.Slides {
+		slides[i] = slide
+		slides[i].Content = append([]MMSSlideContent(nil), slide.Content...)
+	}
+	data.Slides = slides
+	return data
+}
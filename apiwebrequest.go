@@ -8,6 +8,7 @@ import (
 	"fmt"
 	"io/ioutil"
 	"net/http"
+	"strings"
 	"time"
 )
 
@@ -27,13 +28,95 @@ func NewAPIWebRequest(config APIConfig, url string, method string, data string)
 		Url:    url,
 		Method: method,
 		Data:   data,
+		Header: http.Header{},
+		Info:   newRequestInfo(url, method, data),
 	}
 
 	return &r, nil
 }
 
-// Execute the given request using the parameters from NewAPIWebRequest
+// newRequestInfo derives the logical route and message details from the
+// url and JSON body of a request
+func newRequestInfo(url string, method string, data string) RequestInfo {
+	info := RequestInfo{
+		Route:     RouteOther,
+		Method:    method,
+		RequestID: newRequestID(),
+	}
+
+	parts := strings.Split(strings.Trim(url, "/"), "/")
+	switch {
+	case url == "ping":
+		info.Route = RoutePing
+	case url == "message/send":
+		info.Route = RouteSend
+	case url == "message/resend":
+		info.Route = RouteResend
+	case url == "generate/video":
+		info.Route = RouteGenerate
+	case url == "approval/create":
+		info.Route = RouteApprovalCreate
+	case url == "approval/update":
+		info.Route = RouteApprovalUpdate
+	case parts[0] == "scrub":
+		info.Route = RouteScrub
+	case len(parts) == 3 && parts[0] == "approval" && parts[2] == "status":
+		info.Route = RouteApprovalStatus
+	case len(parts) == 3 && parts[0] == "message" && parts[2] == "status":
+		info.Route = RouteStatus
+		info.MessageID = parts[1]
+	}
+
+	if data == "" {
+		return info
+	}
+	var body struct {
+		MVNOID           int
+		Campaign         string
+		Action           int
+		AfterBuildAction int
+		ActionType       int
+		MessageID        string
+	}
+	if json.Unmarshal([]byte(data), &body) != nil {
+		return info
+	}
+	info.MVNOID = body.MVNOID
+	info.Campaign = body.Campaign
+	info.ActionType = body.Action
+	if info.ActionType == 0 {
+		info.ActionType = body.AfterBuildAction
+	}
+	if info.ActionType == 0 {
+		info.ActionType = body.ActionType
+	}
+	if body.MessageID != "" {
+		info.MessageID = body.MessageID
+	}
+	return info
+}
+
+// Execute the given request using the parameters from NewAPIWebRequest,
+// passing it through the middleware chain of the config
 func (r *APIWebRequest) Execute() (string, int, error) {
+	return r.config.handler()(r)
+}
+
+// token returns the access token to send with the request
+func (r *APIWebRequest) token() (string, error) {
+	if r.config.TokenSource == nil {
+		return r.config.AccessToken, nil
+	}
+	return r.config.TokenSource.Token()
+}
+
+// execute sends the request once
+func (r *APIWebRequest) execute() (string, int, error) {
+
+	token, err := r.token()
+	if err != nil {
+		return "", 0, err
+	}
 
 	timeout := time.Duration(time.Second * 30)
 	transport := &http.Transport{}
@@ -44,8 +127,17 @@ func (r *APIWebRequest) Execute() (string, int, error) {
 
 	var jsonBytes = []byte(r.Data)
 	req, err := http.NewRequest(r.Method, r.config.Endpoint+r.Url, bytes.NewBuffer(jsonBytes))
-	req.Header.Add("Content-Type", "application/json")
-	req.Header.Add("Authorization", "Bearer "+r.config.AccessToken)
+	if err != nil {
+		return "", 0, err
+	}
+	for key, values := range r.Header {
+		for _, value := range values {
+			req.Header.Add(key, value)
+		}
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set(RequestIDHeader, r.Info.RequestID)
+	req.Header.Set("Authorization", "Bearer "+token)
 	req.ContentLength = int64(len(jsonBytes))
 	req.Close = true
 
@@ -82,6 +174,16 @@ func (r *APIWebRequest) Execute() (string, int, error) {
 		err = errors.New(responseObj.Error)
 		return "", resp.StatusCode, err
 	}
+}
 
-	return bodyString, resp.StatusCode, nil
+// retryableStatus returns true if a request that failed with the given
+// HTTP status code may succeed when retried
+func retryableStatus(statusCode int) bool {
+	// No response at all, the request may or may not have arrived
+	if statusCode == 0 {
+		return true
+	}
+	return statusCode == http.StatusRequestTimeout ||
+		statusCode == http.StatusTooManyRequests ||
+		statusCode >= http.StatusInternalServerError
 }
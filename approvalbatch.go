@@ -0,0 +1,365 @@
+package messagingapi
+
+import (
+	"errors"
+	"fmt"
+	"sort"
+	"sync"
+)
+
+// ErrIllegalApprovalTransition is wrapped by the errors of ApprovalBatch
+// methods called in a state that doesn't allow them
+var ErrIllegalApprovalTransition = errors.New("Illegal approval batch state transition")
+
+// approvalBatchTransitions lists the states every state can move to
+var approvalBatchTransitions = map[uint32][]uint32{
+	ApprovalBatchStateWaitingData:  {ApprovalBatchStateDataReceived},
+	ApprovalBatchStateDataReceived: {ApprovalBatchStateApprovalSent},
+	ApprovalBatchStateApprovalSent: {ApprovalBatchStateApproved, ApprovalBatchStateDeclined},
+	ApprovalBatchStateApproved:     {ApprovalBatchStateSent},
+}
+
+// ApprovalBatchStateName returns the name of an ApprovalBatchState*
+// constant
+func ApprovalBatchStateName(state uint32) string {
+	switch state {
+	case ApprovalBatchStateWaitingData:
+		return "WaitingData"
+	case ApprovalBatchStateDataReceived:
+		return "DataReceived"
+	case ApprovalBatchStateApprovalSent:
+		return "ApprovalSent"
+	case ApprovalBatchStateApproved:
+		return "Approved"
+	case ApprovalBatchStateDeclined:
+		return "Declined"
+	case ApprovalBatchStateSent:
+		return "Sent"
+	}
+	return fmt.Sprintf("Unknown(%d)", state)
+}
+
+// CanTransitionApproval reports whether an approval batch may move from
+// one state to another:
+// WaitingData → DataReceived → ApprovalSent → Approved/Declined,
+// Approved → Sent
+func CanTransitionApproval(from uint32, to uint32) bool {
+	for _, allowed := range approvalBatchTransitions[from] {
+		if allowed == to {
+			return true
+		}
+	}
+	return false
+}
+
+// ApprovalBatch tracks the state of an approval batch and only allows
+// legal transitions. Messages are added while the batch is WaitingData.
+// It is safe for concurrent use
+type ApprovalBatch struct {
+	api        *MessagingAPI
+	id         uint32
+	actionType uint32
+
+	// lock is held for reading while messages are added, and for writing
+	// while the state changes
+	lock     sync.RWMutex
+	state    uint32
+	resumed  bool
+	countMux sync.Mutex
+	messages int
+	summary  map[approvalSummaryKey]approvalSummaryCount
+}
+
+// approvalSummaryKey groups the messages of a batch in SummaryReport
+type approvalSummaryKey struct {
+	channel string
+	network string
+}
+
+// approvalSummaryCount counts the messages of a group
+type approvalSummaryCount struct {
+	messages   int
+	recipients int
+}
+
+// CreateApprovalBatch creates an approval batch via the API. The batch is
+// nil when the API did not accept the request, check the result
+func (api *MessagingAPI) CreateApprovalBatch(request ApprovalRequest) (*ApprovalBatch, APIResult, error) {
+	switch request.ActionType {
+	case APIActionTypesSubmitMMS, APIActionTypesSubmitSMS, APIActionTypesSubmitEmail:
+	default:
+		return nil, APIResult{}, errors.New("ActionType must be one of SubmitMMS, SubmitSMS or SubmitEmail")
+	}
+
+	result, err := api.CreateApproval(request)
+	if err != nil || result.StatusCode != APIResultStatusesOk {
+		return nil, result, err
+	}
+	if result.RequestResult.BatchID == 0 {
+		result.StatusCode = APIResultStatusesError
+		result.StatusDescription = "API did not return a batch ID"
+		return nil, result, nil
+	}
+
+	batch := &ApprovalBatch{
+		api:        api,
+		id:         result.RequestResult.BatchID,
+		actionType: request.ActionType,
+		state:      ApprovalBatchStateWaitingData,
+	}
+	return batch, result, nil
+}
+
+// OpenApprovalBatch resumes tracking an existing approval batch in a known
+// state, such as one created by another process
+func (api *MessagingAPI) OpenApprovalBatch(batchID uint32, actionType uint32, state uint32) (*ApprovalBatch, error) {
+	if batchID == 0 {
+		return nil, errors.New("Batch ID must not be zero")
+	}
+	if state < ApprovalBatchStateWaitingData || state > ApprovalBatchStateSent {
+		return nil, fmt.Errorf("Unknown approval batch state %d", state)
+	}
+	batch := &ApprovalBatch{
+		api:        api,
+		id:         batchID,
+		actionType: actionType,
+		state:      state,
+		resumed:    true,
+	}
+	return batch, nil
+}
+
+// ID returns the batch ID assigned by the API
+func (b *ApprovalBatch) ID() uint32 {
+	return b.id
+}
+
+// State returns the current ApprovalBatchState* of the batch
+func (b *ApprovalBatch) State() uint32 {
+	b.lock.RLock()
+	defer b.lock.RUnlock()
+
+	return b.state
+}
+
+// Messages returns the amount of messages accepted through AddMessage
+func (b *ApprovalBatch) Messages() int {
+	b.countMux.Lock()
+	defer b.countMux.Unlock()
+
+	return b.messages
+}
+
+// AddMessage sends a NewMessage or BuildRequest as part of the batch,
+// setting its ApprovalBatch. Its action must match the action type of the
+// batch, and the batch must still be WaitingData
+func (b *ApprovalBatch) AddMessage(message interface{}) (APIResult, error) {
+	b.lock.RLock()
+	defer b.lock.RUnlock()
+
+	if b.state != ApprovalBatchStateWaitingData {
+		return APIResult{}, b.transitionError("add messages", b.state)
+	}
+
+	var result APIResult
+	var err error
+	var action int
+	var data interface{}
+	switch m := message.(type) {
+	case NewMessage:
+		action, data = m.Action, m.Data
+		result, err = b.addNewMessage(m)
+	case *NewMessage:
+		action, data = m.Action, m.Data
+		result, err = b.addNewMessage(*m)
+	case BuildRequest:
+		action, data = m.AfterBuildAction, m.AfterBuildData
+		result, err = b.addBuildRequest(m)
+	case *BuildRequest:
+		action, data = m.AfterBuildAction, m.AfterBuildData
+		result, err = b.addBuildRequest(*m)
+	default:
+		return APIResult{}, fmt.Errorf("AddMessage requires a NewMessage or BuildRequest, got %T", message)
+	}
+	if err == nil && result.StatusCode == APIResultStatusesOk {
+		b.record(action, data)
+	}
+	return result, err
+}
+
+// record counts an accepted message for Messages and SummaryReport
+func (b *ApprovalBatch) record(action int, data interface{}) {
+	key := approvalSummaryKey{channel: actionChannelName(action)}
+	recipients := 0
+	switch d := data.(type) {
+	case SubmitSMSMessageData:
+		key.network, recipients = d.Network, len(d.MSISDN)
+	case SubmitMMSMessageData:
+		key.network, recipients = d.Network, len(d.MSISDN)
+	case SubmitEmailMessageData:
+		key.network, recipients = d.Network, len(d.Address)+len(d.CC)+len(d.BCC)
+	}
+
+	b.countMux.Lock()
+	defer b.countMux.Unlock()
+
+	if b.summary == nil {
+		b.summary = make(map[approvalSummaryKey]approvalSummaryCount)
+	}
+	count := b.summary[key]
+	count.messages++
+	count.recipients += recipients
+	b.summary[key] = count
+	b.messages++
+}
+
+// SummaryReport returns a report of the messages added to the batch,
+// counted per channel and network, ending with a total. Pass it to
+// MarkDataReceived so approvers can check the volumes
+func (b *ApprovalBatch) SummaryReport(filename string) (CsvReport, error) {
+	b.countMux.Lock()
+	keys := make([]approvalSummaryKey, 0, len(b.summary))
+	counts := make(map[approvalSummaryKey]approvalSummaryCount, len(b.summary))
+	for key, count := range b.summary {
+		keys = append(keys, key)
+		counts[key] = count
+	}
+	b.countMux.Unlock()
+
+	sort.Slice(keys, func(i, j int) bool {
+		if keys[i].channel != keys[j].channel {
+			return keys[i].channel < keys[j].channel
+		}
+		return keys[i].network < keys[j].network
+	})
+
+	report := NewCsvReportBuilder(filename, "Channel", "Network", "Messages", "Recipients")
+	var total approvalSummaryCount
+	for _, key := range keys {
+		count := counts[key]
+		err := report.AddRow(key.channel, key.network, count.messages, count.recipients)
+		if err != nil {
+			return CsvReport{}, err
+		}
+		total.messages += count.messages
+		total.recipients += count.recipients
+	}
+	err := report.AddRow("Total", "", total.messages, total.recipients)
+	if err != nil {
+		return CsvReport{}, err
+	}
+	return report.Report(), nil
+}
+
+// actionChannelName returns the channel an APIActionTypes* constant sends
+// on
+func actionChannelName(action int) string {
+	switch action {
+	case APIActionTypesSubmitSMS, APIActionTypesArchiveSMS:
+		return "SMS"
+	case APIActionTypesSubmitMMS, APIActionTypesArchiveMMS:
+		return "MMS"
+	case APIActionTypesSubmitEmail, APIActionTypesArchiveEmail:
+		return "Email"
+	case APIActionTypesArchive:
+		return "Archive"
+	}
+	return fmt.Sprintf("Action %d", action)
+}
+
+// addNewMessage sends a message as part of the batch
+func (b *ApprovalBatch) addNewMessage(message NewMessage) (APIResult, error) {
+	if b.actionType != 0 && uint32(message.Action) != b.actionType {
+		return APIResult{}, fmt.Errorf("Approval batch %d only accepts messages with action %d, got %d", b.id, b.actionType, message.Action)
+	}
+	if message.ApprovalBatch != 0 && message.ApprovalBatch != b.id {
+		return APIResult{}, fmt.Errorf("Message is already part of approval batch %d", message.ApprovalBatch)
+	}
+	message.ApprovalBatch = b.id
+	return b.api.Create(message)
+}
+
+// addBuildRequest sends a build request as part of the batch
+func (b *ApprovalBatch) addBuildRequest(request BuildRequest) (APIResult, error) {
+	if b.actionType != 0 && uint32(request.AfterBuildAction) != b.actionType {
+		return APIResult{}, fmt.Errorf("Approval batch %d only accepts build requests with AfterBuildAction %d, got %d", b.id, b.actionType, request.AfterBuildAction)
+	}
+	if request.ApprovalBatch != 0 && request.ApprovalBatch != b.id {
+		return APIResult{}, fmt.Errorf("Build request is already part of approval batch %d", request.ApprovalBatch)
+	}
+	request.ApprovalBatch = b.id
+	return b.api.Generate(request)
+}
+
+// MarkDataReceived tells the API every message has been added, with
+// optional reports for the approvers. Moves WaitingData → DataReceived
+func (b *ApprovalBatch) MarkDataReceived(reports ...CsvReport) (APIResult, error) {
+	b.lock.Lock()
+	defer b.lock.Unlock()
+
+	if b.state == ApprovalBatchStateWaitingData && !b.resumed && b.Messages() == 0 {
+		return APIResult{}, fmt.Errorf("Approval batch %d has no messages, add messages before marking data received", b.id)
+	}
+	return b.transition(ApprovalBatchStateDataReceived, reports)
+}
+
+// MarkApprovalSent records that approval requests were sent to the
+// approvers. Moves DataReceived → ApprovalSent
+func (b *ApprovalBatch) MarkApprovalSent() (APIResult, error) {
+	b.lock.Lock()
+	defer b.lock.Unlock()
+
+	return b.transition(ApprovalBatchStateApprovalSent, nil)
+}
+
+// MarkApproved records that the batch was approved. Moves
+// ApprovalSent → Approved
+func (b *ApprovalBatch) MarkApproved() (APIResult, error) {
+	b.lock.Lock()
+	defer b.lock.Unlock()
+
+	return b.transition(ApprovalBatchStateApproved, nil)
+}
+
+// MarkDeclined records that the batch was declined, its messages won't be
+// sent. Moves ApprovalSent → Declined
+func (b *ApprovalBatch) MarkDeclined() (APIResult, error) {
+	b.lock.Lock()
+	defer b.lock.Unlock()
+
+	return b.transition(ApprovalBatchStateDeclined, nil)
+}
+
+// Finalize releases the messages of an approved batch for sending. Moves
+// Approved → Sent
+func (b *ApprovalBatch) Finalize() (APIResult, error) {
+	b.lock.Lock()
+	defer b.lock.Unlock()
+
+	return b.transition(ApprovalBatchStateSent, nil)
+}
+
+// transition updates the state via the API when the move is legal. The
+// state only changes when the API accepted the update. The caller must
+// hold the write lock
+func (b *ApprovalBatch) transition(to uint32, reports []CsvReport) (APIResult, error) {
+	if !CanTransitionApproval(b.state, to) {
+		return APIResult{}, b.transitionError("move to "+ApprovalBatchStateName(to), b.state)
+	}
+
+	result, err := b.api.UpdateApproval(ApprovalUpdateRequest{
+		BatchID: b.id,
+		State:   to,
+		Reports: reports,
+	})
+	if err != nil || result.StatusCode != APIResultStatusesOk {
+		return result, err
+	}
+	b.state = to
+	return result, nil
+}
+
+// transitionError describes an action the current state doesn't allow
+func (b *ApprovalBatch) transitionError(action string, state uint32) error {
+	return fmt.Errorf("%w: approval batch %d can not %s while %s", ErrIllegalApprovalTransition, b.id, action, ApprovalBatchStateName(state))
+}
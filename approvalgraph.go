@@ -0,0 +1,317 @@
+package messagingapi
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+	"sync"
+)
+
+// ApprovalGraph declares approval batches gated on each other, such as SMS
+// and email batches waiting on a statement batch. Every batch links to at
+// most one parent, as ApprovalRequest.LinkedApproval holds a single batch.
+// Batches are created in topological order by CreateApprovalGraph. It is
+// safe for concurrent use
+type ApprovalGraph struct {
+	lock  sync.Mutex
+	nodes map[string]*approvalNode
+	names []string
+	// creating is set while CreateApprovalGraph runs
+	creating bool
+}
+
+// approvalNode is a batch in an ApprovalGraph
+type approvalNode struct {
+	name    string
+	parent  string
+	request ApprovalRequest
+	batch   *ApprovalBatch
+}
+
+// NewApprovalGraph creates an empty graph
+func NewApprovalGraph() *ApprovalGraph {
+	return &ApprovalGraph{nodes: make(map[string]*approvalNode)}
+}
+
+// Add declares a batch by name, linked to the parent batch when parent
+// isn't blank. Parents may be added later, they are checked by Order.
+// LinkedApproval is set by the graph and must be zero
+func (g *ApprovalGraph) Add(name string, request ApprovalRequest, parent string) error {
+	if name == "" {
+		return errors.New("Batch name can not be blank")
+	}
+	if name == parent {
+		return fmt.Errorf("Batch %q can not be linked to itself", name)
+	}
+	if request.LinkedApproval != 0 {
+		return fmt.Errorf("Batch %q must not set LinkedApproval, it is set from the parent", name)
+	}
+
+	g.lock.Lock()
+	defer g.lock.Unlock()
+
+	if _, ok := g.nodes[name]; ok {
+		return fmt.Errorf("Batch %q was already added", name)
+	}
+	g.nodes[name] = &approvalNode{name: name, parent: parent, request: request}
+	g.names = append(g.names, name)
+	return nil
+}
+
+// Batch returns the created batch by name, nil if it was not created yet
+func (g *ApprovalGraph) Batch(name string) *ApprovalBatch {
+	g.lock.Lock()
+	defer g.lock.Unlock()
+
+	if node, ok := g.nodes[name]; ok {
+		return node.batch
+	}
+	return nil
+}
+
+// Order returns the batch names with every parent before its children,
+// keeping the order batches were added in where possible. It fails on
+// unknown parents and cycles
+func (g *ApprovalGraph) Order() ([]string, error) {
+	g.lock.Lock()
+	defer g.lock.Unlock()
+
+	return g.order()
+}
+
+// order implements Order, the caller must hold the lock
+func (g *ApprovalGraph) order() ([]string, error) {
+	children := make(map[string][]string)
+	var queue []string
+	for _, name := range g.names {
+		parent := g.nodes[name].parent
+		if parent == "" {
+			queue = append(queue, name)
+			continue
+		}
+		if _, ok := g.nodes[parent]; !ok {
+			return nil, fmt.Errorf("Batch %q is linked to unknown batch %q", name, parent)
+		}
+		children[parent] = append(children[parent], name)
+	}
+
+	order := make([]string, 0, len(g.names))
+	for len(queue) > 0 {
+		name := queue[0]
+		queue = queue[1:]
+		order = append(order, name)
+		queue = append(queue, children[name]...)
+	}
+	if len(order) == len(g.names) {
+		return order, nil
+	}
+
+	// Every batch left over is on, or below, a cycle
+	ordered := make(map[string]bool, len(order))
+	for _, name := range order {
+		ordered[name] = true
+	}
+	for _, name := range g.names {
+		if !ordered[name] {
+			return nil, fmt.Errorf("Linked approvals form a cycle: %s", g.cycleFrom(name))
+		}
+	}
+	return nil, errors.New("Linked approvals form a cycle")
+}
+
+// cycleFrom follows the parents of a batch until a batch repeats and
+// describes the cycle, such as "a -> b -> a"
+func (g *ApprovalGraph) cycleFrom(name string) string {
+	position := make(map[string]int)
+	var path []string
+	for {
+		if start, ok := position[name]; ok {
+			return strings.Join(append(path[start:], name), " -> ")
+		}
+		position[name] = len(path)
+		path = append(path, name)
+		name = g.nodes[name].parent
+	}
+}
+
+// CreateApprovalGraph creates every batch of the graph that was not created
+// yet, parents first, linking each batch to its parent. On failure the
+// batches created so far are kept, calling it again continues from the
+// failed batch. The graph isn't locked while batches are created, only
+// one CreateApprovalGraph may run on a graph at a time. The result is that
+// of the last create request
+func (api *MessagingAPI) CreateApprovalGraph(graph *ApprovalGraph) (APIResult, error) {
+	graph.lock.Lock()
+	order, err := graph.order()
+	if err == nil && len(order) == 0 {
+		err = errors.New("Approval graph has no batches")
+	}
+	if err == nil && graph.creating {
+		err = errors.New("Approval graph is already being created")
+	}
+	if err != nil {
+		graph.lock.Unlock()
+		return APIResult{}, err
+	}
+	graph.creating = true
+	graph.lock.Unlock()
+
+	defer func() {
+		graph.lock.Lock()
+		graph.creating = false
+		graph.lock.Unlock()
+	}()
+
+	var result APIResult
+	for _, name := range order {
+		request, create := graph.pendingRequest(name)
+		if !create {
+			continue
+		}
+
+		var batch *ApprovalBatch
+		batch, result, err = api.CreateApprovalBatch(request)
+		if err != nil {
+			return result, fmt.Errorf("Unable to create approval batch %q: %s", name, err.Error())
+		}
+		if batch == nil {
+			return result, fmt.Errorf("Unable to create approval batch %q: %s", name, result.StatusDescription)
+		}
+
+		graph.lock.Lock()
+		graph.nodes[name].batch = batch
+		graph.lock.Unlock()
+	}
+	return result, nil
+}
+
+// pendingRequest returns the request of a batch linked to its created
+// parent, false when the batch was already created
+func (g *ApprovalGraph) pendingRequest(name string) (ApprovalRequest, bool) {
+	g.lock.Lock()
+	defer g.lock.Unlock()
+
+	node := g.nodes[name]
+	if node.batch != nil {
+		return ApprovalRequest{}, false
+	}
+	request := node.request
+	if node.parent != "" {
+		request.LinkedApproval = g.nodes[node.parent].batch.ID()
+	}
+	return request, true
+}
+
+// Refresh updates the state of every created batch from the API
+func (g *ApprovalGraph) Refresh() error {
+	for _, batch := range g.batches() {
+		result, err := batch.Refresh()
+		if err != nil {
+			return err
+		}
+		if result.StatusCode != APIResultStatusesOk {
+			return fmt.Errorf("Unable to get the status of approval batch %d: %s", batch.ID(), result.StatusDescription)
+		}
+	}
+	return nil
+}
+
+// ApplyEvent updates the batch an approval postback is for, returning
+// false when the event is for a batch outside the graph
+func (g *ApprovalGraph) ApplyEvent(event ApprovalEvent) (bool, error) {
+	for _, batch := range g.batches() {
+		if batch.ID() == event.BatchID {
+			return true, batch.ApplyEvent(event)
+		}
+	}
+	return false, nil
+}
+
+// batches returns the created batches
+func (g *ApprovalGraph) batches() []*ApprovalBatch {
+	g.lock.Lock()
+	defer g.lock.Unlock()
+
+	var batches []*ApprovalBatch
+	for _, name := range g.names {
+		if batch := g.nodes[name].batch; batch != nil {
+			batches = append(batches, batch)
+		}
+	}
+	return batches
+}
+
+// ApprovalNodeStatus is the readiness of a batch in an ApprovalGraph
+type ApprovalNodeStatus struct {
+	Name   string
+	Parent string
+	// BatchID is zero until the batch is created
+	BatchID uint32
+	// State is the known ApprovalBatchState*, zero until created
+	State uint32
+	// Ready is true when the batch and all its ancestors received their
+	// data, so its approvals can be sent
+	Ready bool
+	// Reason describes why the batch isn't ready
+	Reason string
+}
+
+// ApprovalGraphStatus is the readiness of a whole ApprovalGraph
+type ApprovalGraphStatus struct {
+	// Nodes are in topological order
+	Nodes []ApprovalNodeStatus
+	// Ready is true when every batch is ready
+	Ready bool
+	// Approved is true when every batch was approved
+	Approved bool
+	// Declined lists the batches that were declined
+	Declined []string
+}
+
+// Status reports the readiness of every batch from its known state. Use
+// Refresh or ApplyEvent to update the states first
+func (g *ApprovalGraph) Status() (ApprovalGraphStatus, error) {
+	g.lock.Lock()
+	defer g.lock.Unlock()
+
+	order, err := g.order()
+	if err != nil {
+		return ApprovalGraphStatus{}, err
+	}
+
+	status := ApprovalGraphStatus{Ready: len(order) > 0, Approved: len(order) > 0}
+	byName := make(map[string]ApprovalNodeStatus, len(order))
+	for _, name := range order {
+		node := g.nodes[name]
+		nodeStatus := ApprovalNodeStatus{Name: name, Parent: node.parent}
+		if node.batch != nil {
+			nodeStatus.BatchID = node.batch.ID()
+			nodeStatus.State = node.batch.State()
+		}
+
+		parent, hasParent := byName[node.parent]
+		switch {
+		case node.batch == nil:
+			nodeStatus.Reason = "Batch has not been created"
+		case nodeStatus.State == ApprovalBatchStateDeclined:
+			nodeStatus.Reason = "Batch was declined"
+			status.Declined = append(status.Declined, name)
+		case nodeStatus.State == ApprovalBatchStateWaitingData:
+			nodeStatus.Reason = "Batch is waiting for data"
+		case hasParent && !parent.Ready:
+			nodeStatus.Reason = fmt.Sprintf("Linked batch %q is not ready", node.parent)
+		default:
+			nodeStatus.Ready = true
+		}
+
+		if !nodeStatus.Ready {
+			status.Ready = false
+		}
+		if nodeStatus.State != ApprovalBatchStateApproved && nodeStatus.State != ApprovalBatchStateSent {
+			status.Approved = false
+		}
+		byName[name] = nodeStatus
+		status.Nodes = append(status.Nodes, nodeStatus)
+	}
+	return status, nil
+}
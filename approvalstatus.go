@@ -0,0 +1,233 @@
+package messagingapi
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strconv"
+	"time"
+)
+
+// DefaultApprovalPollInterval is how often WaitForApproval checks the
+// status of a batch
+const DefaultApprovalPollInterval = 10 * time.Second
+
+// ErrApprovalTimeout is returned by WaitForApproval when the batch was not
+// approved or declined in time
+var ErrApprovalTimeout = errors.New("Timed out waiting for approval")
+
+// ApprovalStatus is returned from the API when approval/:id/status is
+// called. The fields are provisional until the API documents the route
+type ApprovalStatus struct {
+	BatchID uint32 `json:"batch_id"`
+	Name    string `json:"name"`
+	// State is one of ApprovalBatchState*
+	State        uint32 `json:"state"`
+	MaxApprovals uint32 `json:"max_approvals"`
+	// Approvals and Declines count the responses received so far
+	Approvals uint32             `json:"approvals"`
+	Declines  uint32             `json:"declines"`
+	Responses []ApprovalResponse `json:"responses,omitempty"`
+}
+
+// Decided reports whether the batch was approved or declined. A batch
+// that was sent was approved first
+func (status ApprovalStatus) Decided() bool {
+	switch status.State {
+	case ApprovalBatchStateApproved, ApprovalBatchStateDeclined, ApprovalBatchStateSent:
+		return true
+	}
+	return false
+}
+
+// ApprovalResponse is the response of a single approver
+type ApprovalResponse struct {
+	Person ApprovalPerson `json:"person"`
+	// Internal is true for InternalPeople, false for ExternalPeople
+	Internal  bool      `json:"internal"`
+	Approved  bool      `json:"approved"`
+	Comment   string    `json:"comment,omitempty"`
+	Timestamp time.Time `json:"timestamp"`
+}
+
+// Approval event types
+const (
+	ApprovalEventResponse    = "response"
+	ApprovalEventStateChange = "state_change"
+)
+
+// ApprovalEvent is POSTed to ApprovalRequest.PostbackUrl when an approver
+// responds or the state of the batch changes. The fields are provisional
+// until the API documents approval postbacks
+type ApprovalEvent struct {
+	// Type is one of ApprovalEvent*
+	Type    string `json:"type"`
+	BatchID uint32 `json:"batch_id"`
+	// State is the ApprovalBatchState* of the batch after the event
+	State uint32 `json:"state"`
+	// Response is set for ApprovalEventResponse events
+	Response *ApprovalResponse `json:"response,omitempty"`
+	// The amount of times the event was retried to you
+	RetryCount   uint32    `json:"retry_count,omitempty"`
+	Timestamp    time.Time `json:"timestamp"`
+	PostbackType string    `json:"postback_type,omitempty"`
+}
+
+// ParseApprovalEvent unmarshals the body of an approval postback
+func ParseApprovalEvent(body []byte) (ApprovalEvent, error) {
+	var event ApprovalEvent
+	err := json.Unmarshal(body, &event)
+	if err != nil {
+		return event, err
+	}
+	if event.BatchID == 0 {
+		return event, errors.New("Approval event has no batch ID")
+	}
+	return event, nil
+}
+
+// GetApprovalStatus makes a simple GET call to the API to get the status
+// of an approval batch. The approval/:id/status route is provisional, it
+// mirrors message/:id/status until the API documents it
+func (api *MessagingAPI) GetApprovalStatus(batchID uint32) (APIResult, error) {
+	result, _, err := api.getApprovalStatus(batchID)
+	return result, err
+}
+
+// getApprovalStatus implements GetApprovalStatus, also returning the HTTP
+// status code of the response
+func (api *MessagingAPI) getApprovalStatus(batchID uint32) (APIResult, int, error) {
+	result := APIResult{}
+
+	if batchID == 0 {
+		return result, 0, errors.New("Batch ID must not be zero")
+	}
+
+	r, err := NewAPIWebRequest(api.config, "approval/"+strconv.FormatUint(uint64(batchID), 10)+"/status", "GET", "")
+	if err != nil {
+		return result, 0, err
+	}
+
+	responseBody, statusCode, err := r.Execute()
+	if err != nil {
+		result = HandleErrorResponse(result, statusCode, err)
+		if errors.Is(err, ErrCircuitOpen) {
+			return result, statusCode, err
+		}
+	} else {
+
+		var status ApprovalStatus
+		err = json.Unmarshal([]byte(responseBody), &status)
+		if err != nil {
+			result.StatusCode = APIResultStatusesError
+			result.StatusDescription = "Unable to unmarshal result from API"
+			return result, statusCode, nil
+		}
+		result.ApprovalStatus = status
+		result.StatusCode = APIResultStatusesOk
+		result.StatusDescription = "Ok"
+	}
+	return result, statusCode, nil
+}
+
+// WaitForApproval polls the status of the batch every interval until it
+// is approved or declined, returning the last status. Polls failing with
+// a network error, a server error or rate limiting are retried until the
+// timeout, after which ErrApprovalTimeout is returned. Other failures,
+// such as an unknown batch, are returned right away. A zero interval uses
+// DefaultApprovalPollInterval
+func (api *MessagingAPI) WaitForApproval(batchID uint32, timeout time.Duration, interval time.Duration) (ApprovalStatus, error) {
+	if interval <= 0 {
+		interval = DefaultApprovalPollInterval
+	}
+	deadline := time.Now().Add(timeout)
+
+	var status ApprovalStatus
+	lastFailure := ""
+	for {
+		result, statusCode, err := api.getApprovalStatus(batchID)
+		if err != nil {
+			return status, err
+		}
+		if result.StatusCode == APIResultStatusesOk {
+			status = result.ApprovalStatus
+			if status.Decided() {
+				return status, nil
+			}
+			lastFailure = ""
+		} else if !retryableStatus(statusCode) {
+			return status, fmt.Errorf("Unable to get the status of approval batch %d: %s", batchID, result.StatusDescription)
+		} else {
+			lastFailure = result.StatusDescription
+		}
+
+		remaining := time.Until(deadline)
+		if remaining <= 0 {
+			if lastFailure != "" {
+				return status, fmt.Errorf("%w for batch %d, last poll failed: %s", ErrApprovalTimeout, batchID, lastFailure)
+			}
+			return status, fmt.Errorf("%w for batch %d, batch is %s", ErrApprovalTimeout, batchID, ApprovalBatchStateName(status.State))
+		}
+		if remaining < interval {
+			time.Sleep(remaining)
+		} else {
+			time.Sleep(interval)
+		}
+	}
+}
+
+// Refresh updates the state of the batch from the API
+func (b *ApprovalBatch) Refresh() (APIResult, error) {
+	result, err := b.api.GetApprovalStatus(b.id)
+	if err != nil || result.StatusCode != APIResultStatusesOk {
+		return result, err
+	}
+	return result, b.observe(result.ApprovalStatus.State)
+}
+
+// ApplyEvent updates the state of the batch from an approval postback
+func (b *ApprovalBatch) ApplyEvent(event ApprovalEvent) error {
+	if event.BatchID != b.id {
+		return fmt.Errorf("Approval event is for batch %d, not %d", event.BatchID, b.id)
+	}
+	return b.observe(event.State)
+}
+
+// Wait blocks until the batch is approved or declined, see
+// WaitForApproval, and updates its state
+func (b *ApprovalBatch) Wait(timeout time.Duration, interval time.Duration) (ApprovalStatus, error) {
+	status, err := b.api.WaitForApproval(b.id, timeout, interval)
+	if status.State != 0 {
+		if observeErr := b.observe(status.State); observeErr != nil && err == nil {
+			err = observeErr
+		}
+	}
+	return status, err
+}
+
+// observe moves the batch to a state reported by the API. Repeated or
+// stale states, such as a postback retried after a later one, are ignored
+func (b *ApprovalBatch) observe(state uint32) error {
+	b.lock.Lock()
+	defer b.lock.Unlock()
+
+	if state == b.state || approvalStateReachable(state, b.state) {
+		return nil
+	}
+	if !approvalStateReachable(b.state, state) {
+		return b.transitionError("move to "+ApprovalBatchStateName(state), b.state)
+	}
+	b.state = state
+	return nil
+}
+
+// approvalStateReachable reports whether a batch can get from one state
+// to another through one or more transitions
+func approvalStateReachable(from uint32, to uint32) bool {
+	for _, next := range approvalBatchTransitions[from] {
+		if next == to || approvalStateReachable(next, to) {
+			return true
+		}
+	}
+	return false
+}
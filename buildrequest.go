@@ -37,6 +37,9 @@ type BuildRequest struct {
 	SubmitNotBefore string
 	// This message should not be submitted after this date, format yyyy-mm-dd hh:mm
 	SubmitNotAfter string
+	// IdempotencyKey identifies this logical send, a repeated key returns
+	// the original result instead of sending again (optional)
+	IdempotencyKey string `json:"-"`
 	// Error is the last error that occurred within Validate()
 	Error string
 }
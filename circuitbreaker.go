@@ -0,0 +1,192 @@
+package messagingapi
+
+import (
+	"errors"
+	"sync"
+	"time"
+)
+
+// Circuit breaker states
+const (
+	// Requests are sent and failures are counted
+	CircuitClosed = "closed"
+	// Requests fail fast with ErrCircuitOpen
+	CircuitOpen = "open"
+	// A limited amount of probe requests are sent to test recovery
+	CircuitHalfOpen = "half_open"
+)
+
+// ErrCircuitOpen is returned for requests rejected by an open breaker, the
+// API methods return it along with APIResultStatusesCircuitOpen
+var ErrCircuitOpen = errors.New("Circuit breaker is open, the Messaging API is unavailable")
+
+// CircuitBreakerConfig is the configuration passed to NewCircuitBreaker
+type CircuitBreakerConfig struct {
+	// FailureThresholds is the amount of consecutive failures of an
+	// ErrorClass* that opens the breaker. Classes not listed are not
+	// counted. Defaults to 5 for network, timeout and server errors
+	FailureThresholds map[string]int
+	// OpenTimeout is how long the breaker stays open before probing,
+	// defaults to 30 seconds
+	OpenTimeout time.Duration
+	// HalfOpenProbes is the amount of successful probes needed to close
+	// the breaker, defaults to 1
+	HalfOpenProbes int
+	// OnStateChange is called whenever the state changes, while the
+	// breaker is locked so it must not call back into it (optional)
+	OnStateChange func(from string, to string)
+}
+
+// CircuitBreaker stops requests to the API after a run of failures, so
+// callers fail fast instead of waiting on timeouts while it is degraded
+type CircuitBreaker struct {
+	lock      sync.Mutex
+	config    CircuitBreakerConfig
+	state     string
+	failures  map[string]int
+	openedAt  time.Time
+	probes    int
+	successes int
+}
+
+// NewCircuitBreaker creates a closed breaker
+func NewCircuitBreaker(config CircuitBreakerConfig) *CircuitBreaker {
+	if len(config.FailureThresholds) == 0 {
+		config.FailureThresholds = map[string]int{
+			ErrorClassNetwork: 5,
+			ErrorClassTimeout: 5,
+			ErrorClassServer:  5,
+		}
+	}
+	if config.OpenTimeout <= 0 {
+		config.OpenTimeout = 30 * time.Second
+	}
+	if config.HalfOpenProbes <= 0 {
+		config.HalfOpenProbes = 1
+	}
+
+	return &CircuitBreaker{
+		config:   config,
+		state:    CircuitClosed,
+		failures: make(map[string]int),
+	}
+}
+
+// State returns the current state, one of Circuit*
+func (b *CircuitBreaker) State() string {
+	b.lock.Lock()
+	defer b.lock.Unlock()
+
+	b.expire()
+	return b.state
+}
+
+// Allow returns ErrCircuitOpen if a request may not be sent now. Every
+// allowed request must be followed by a call to Record
+func (b *CircuitBreaker) Allow() error {
+	b.lock.Lock()
+	defer b.lock.Unlock()
+
+	b.expire()
+	switch b.state {
+	case CircuitOpen:
+		return ErrCircuitOpen
+	case CircuitHalfOpen:
+		if b.probes >= b.config.HalfOpenProbes {
+			return ErrCircuitOpen
+		}
+		b.probes++
+	}
+	return nil
+}
+
+// Record counts the outcome of an allowed request, given as one of
+// ErrorClass*
+func (b *CircuitBreaker) Record(errorClass string) {
+	b.lock.Lock()
+	defer b.lock.Unlock()
+
+	threshold, counted := b.config.FailureThresholds[errorClass]
+	failed := errorClass != ErrorClassNone && counted
+
+	switch b.state {
+	case CircuitHalfOpen:
+		if b.probes > 0 {
+			b.probes--
+		}
+		if failed {
+			b.open()
+			return
+		}
+		b.successes++
+		if b.successes >= b.config.HalfOpenProbes {
+			b.setState(CircuitClosed)
+			b.failures = make(map[string]int)
+		}
+
+	case CircuitClosed:
+		if errorClass == ErrorClassNone {
+			b.failures = make(map[string]int)
+			return
+		}
+		if !failed {
+			return
+		}
+		b.failures[errorClass]++
+		if b.failures[errorClass] >= threshold {
+			b.open()
+		}
+	}
+}
+
+// Middleware returns a Middleware failing requests fast with ErrCircuitOpen
+// while the breaker is open
+func (b *CircuitBreaker) Middleware() Middleware {
+	return func(next RequestHandler) RequestHandler {
+		return func(r *APIWebRequest) (string, int, error) {
+			if err := b.Allow(); err != nil {
+				return "", 0, err
+			}
+			body, statusCode, err := next(r)
+			b.Record(ClassifyError(statusCode, err))
+			return body, statusCode, err
+		}
+	}
+}
+
+// expire moves an open breaker to half open once OpenTimeout passed
+func (b *CircuitBreaker) expire() {
+	if b.state == CircuitOpen && time.Since(b.openedAt) >= b.config.OpenTimeout {
+		b.probes = 0
+		b.successes = 0
+		b.setState(CircuitHalfOpen)
+	}
+}
+
+// open opens the breaker
+func (b *CircuitBreaker) open() {
+	b.openedAt = time.Now()
+	b.failures = make(map[string]int)
+	b.setState(CircuitOpen)
+}
+
+// setState changes the state and notifies OnStateChange
+func (b *CircuitBreaker) setState(state string) {
+	if b.state == state {
+		return
+	}
+	from := b.state
+	b.state = state
+	if b.config.OnStateChange != nil {
+		b.config.OnStateChange(from, state)
+	}
+}
+
+// CircuitState returns the state of the configured CircuitBreaker, or
+// CircuitClosed when none is configured
+func (api *MessagingAPI) CircuitState() string {
+	if api.config.CircuitBreaker == nil {
+		return CircuitClosed
+	}
+	return api.config.CircuitBreaker.State()
+}
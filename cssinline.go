@@ -0,0 +1,318 @@
+package messagingapi
+
+import (
+	"regexp"
+	"sort"
+	"strings"
+)
+
+// cssSimpleSelector matches the selectors InlineCSS can apply: an
+// optional element name followed by any amount of #id and .class parts
+var cssSimpleSelector = regexp.MustCompile(`^([a-zA-Z][a-zA-Z0-9]*|\*)?((?:[.#][-_a-zA-Z0-9]+)*)$`)
+
+// cssRule is a style rule with a simple selector
+type cssRule struct {
+	element      string
+	ids          []string
+	classes      []string
+	specificity  int
+	order        int
+	declarations []cssDeclaration
+}
+
+// cssDeclaration is a property and its value
+type cssDeclaration struct {
+	property string
+	value    string
+}
+
+// InlineCSS moves the rules of <style> blocks into the style attributes of
+// the elements they select, as many email clients ignore style blocks.
+// Only simple selectors such as p, .note, #header and td.total are
+// inlined, applied in order of specificity. Existing style attributes take
+// precedence. Rules that can't be inlined, such as @media queries and
+// descendant selectors, are kept in their style block
+func InlineCSS(source string) string {
+	tokens := tokenizeHTML(source)
+
+	var rules []cssRule
+	remove := make(map[int]bool)
+	for i := 0; i < len(tokens); i++ {
+		token := tokens[i]
+		if token.kind != htmlStartTag || token.name != "style" || token.selfClosing {
+			continue
+		}
+		if media, ok := token.attr("media"); ok && media != "all" && media != "screen" {
+			continue
+		}
+		if i+1 >= len(tokens) || tokens[i+1].kind != htmlText {
+			continue
+		}
+
+		inlined, kept := parseCSS(tokens[i+1].raw, len(rules))
+		rules = append(rules, inlined...)
+		if strings.TrimSpace(kept) != "" {
+			tokens[i+1].raw = kept
+			continue
+		}
+		// Everything was inlined, drop the style block
+		remove[i] = true
+		remove[i+1] = true
+		if i+2 < len(tokens) && tokens[i+2].kind == htmlEndTag && tokens[i+2].name == "style" {
+			remove[i+2] = true
+		}
+	}
+	if len(rules) == 0 {
+		return source
+	}
+	sort.SliceStable(rules, func(i, j int) bool {
+		if rules[i].specificity != rules[j].specificity {
+			return rules[i].specificity < rules[j].specificity
+		}
+		return rules[i].order < rules[j].order
+	})
+
+	var out strings.Builder
+	for i, token := range tokens {
+		if remove[i] {
+			continue
+		}
+		if token.kind == htmlStartTag && !htmlSkippedElements[token.name] && token.name != "html" {
+			inlineRules(&token, rules)
+		}
+		out.WriteString(token.raw)
+	}
+	return out.String()
+}
+
+// inlineRules sets the style attribute of the element from the matching
+// rules, keeping existing declarations last so they take precedence
+func inlineRules(token *htmlToken, rules []cssRule) {
+	var declarations []cssDeclaration
+	for _, rule := range rules {
+		if rule.matches(*token) {
+			declarations = append(declarations, rule.declarations...)
+		}
+	}
+	if len(declarations) == 0 {
+		return
+	}
+	if style, ok := token.attr("style"); ok {
+		declarations = append(declarations, parseCSSDeclarations(style)...)
+	}
+
+	// Later declarations of a property replace earlier ones in place
+	var merged []cssDeclaration
+	index := make(map[string]int)
+	for _, declaration := range declarations {
+		if i, ok := index[declaration.property]; ok {
+			merged[i] = declaration
+			continue
+		}
+		index[declaration.property] = len(merged)
+		merged = append(merged, declaration)
+	}
+
+	var style []string
+	for _, declaration := range merged {
+		style = append(style, declaration.property+": "+declaration.value)
+	}
+	token.setAttr("style", strings.Join(style, "; "))
+}
+
+// matches returns true if the rule selects the element
+func (rule cssRule) matches(token htmlToken) bool {
+	if rule.element != "" && rule.element != "*" && rule.element != token.name {
+		return false
+	}
+	if len(rule.ids) > 0 {
+		id, _ := token.attr("id")
+		for _, want := range rule.ids {
+			if id != want {
+				return false
+			}
+		}
+	}
+	if len(rule.classes) > 0 {
+		class, _ := token.attr("class")
+		classes := make(map[string]bool)
+		for _, name := range strings.Fields(class) {
+			classes[name] = true
+		}
+		for _, want := range rule.classes {
+			if !classes[want] {
+				return false
+			}
+		}
+	}
+	return true
+}
+
+// parseCSS splits a stylesheet into the rules that can be inlined and the
+// source of the rules that must be kept. Order numbers the inlined rules
+// from the given start
+func parseCSS(css string, order int) ([]cssRule, string) {
+	// Drop comments
+	for {
+		start := strings.Index(css, "/*")
+		if start < 0 {
+			break
+		}
+		end := strings.Index(css[start+2:], "*/")
+		if end < 0 {
+			css = css[:start]
+			break
+		}
+		css = css[:start] + css[start+2+end+2:]
+	}
+
+	var rules []cssRule
+	var kept strings.Builder
+	for {
+		css = strings.TrimSpace(css)
+		if css == "" {
+			break
+		}
+
+		if strings.HasPrefix(css, "@") {
+			// At-rules are kept, either up to ; or their whole block
+			semicolon := strings.IndexByte(css, ';')
+			brace := strings.IndexByte(css, '{')
+			end := len(css)
+			if semicolon >= 0 && (brace < 0 || semicolon < brace) {
+				end = semicolon + 1
+			} else if brace >= 0 {
+				end = matchingBrace(css, brace) + 1
+			}
+			kept.WriteString(css[:end] + "\n")
+			css = css[end:]
+			continue
+		}
+
+		brace := strings.IndexByte(css, '{')
+		if brace < 0 {
+			break
+		}
+		selectors := css[:brace]
+		body := css[brace+1:]
+		css = ""
+		if closing := strings.IndexByte(body, '}'); closing >= 0 {
+			css = body[closing+1:]
+			body = body[:closing]
+		}
+
+		declarations := parseCSSDeclarations(body)
+		var unsupported []string
+		for _, selector := range strings.Split(selectors, ",") {
+			selector = strings.TrimSpace(selector)
+			rule, ok := parseCSSSelector(selector)
+			if !ok {
+				unsupported = append(unsupported, selector)
+				continue
+			}
+			rule.order = order
+			rule.declarations = declarations
+			rules = append(rules, rule)
+			order++
+		}
+		if len(unsupported) > 0 {
+			kept.WriteString(strings.Join(unsupported, ", ") + " {" + body + "}\n")
+		}
+	}
+	return rules, kept.String()
+}
+
+// parseCSSSelector parses a simple selector
+func parseCSSSelector(selector string) (cssRule, bool) {
+	match := cssSimpleSelector.FindStringSubmatch(selector)
+	if match == nil || selector == "" {
+		return cssRule{}, false
+	}
+
+	rule := cssRule{element: strings.ToLower(match[1])}
+	if rule.element != "" && rule.element != "*" {
+		rule.specificity = 1
+	}
+	parts := match[2]
+	for parts != "" {
+		end := strings.IndexAny(parts[1:], ".#")
+		if end < 0 {
+			end = len(parts)
+		} else {
+			end++
+		}
+		if parts[0] == '#' {
+			rule.ids = append(rule.ids, parts[1:end])
+			rule.specificity += 100
+		} else {
+			rule.classes = append(rule.classes, parts[1:end])
+			rule.specificity += 10
+		}
+		parts = parts[end:]
+	}
+	return rule, true
+}
+
+// parseCSSDeclarations parses the declarations of a rule or style attribute
+func parseCSSDeclarations(body string) []cssDeclaration {
+	var declarations []cssDeclaration
+	for _, part := range splitCSSDeclarations(body) {
+		colon := strings.IndexByte(part, ':')
+		if colon < 0 {
+			continue
+		}
+		property := strings.ToLower(strings.TrimSpace(part[:colon]))
+		value := strings.TrimSpace(part[colon+1:])
+		if property == "" || value == "" {
+			continue
+		}
+		declarations = append(declarations, cssDeclaration{property: property, value: value})
+	}
+	return declarations
+}
+
+// splitCSSDeclarations splits on the semicolons between declarations,
+// ignoring those in quotes and parentheses such as data URLs
+func splitCSSDeclarations(body string) []string {
+	var parts []string
+	depth := 0
+	var quote byte
+	start := 0
+	for i := 0; i < len(body); i++ {
+		c := body[i]
+		switch {
+		case quote != 0:
+			if c == quote {
+				quote = 0
+			}
+		case c == '"' || c == '\'':
+			quote = c
+		case c == '(':
+			depth++
+		case c == ')' && depth > 0:
+			depth--
+		case c == ';' && depth == 0:
+			parts = append(parts, body[start:i])
+			start = i + 1
+		}
+	}
+	return append(parts, body[start:])
+}
+
+// matchingBrace returns the index of the brace closing the one at open,
+// or the last index if it isn't closed
+func matchingBrace(css string, open int) int {
+	depth := 0
+	for i := open; i < len(css); i++ {
+		switch css[i] {
+		case '{':
+			depth++
+		case '}':
+			depth--
+			if depth == 0 {
+				return i
+			}
+		}
+	}
+	return len(css) - 1
+}
@@ -0,0 +1,75 @@
+package messagingapi
+
+import "testing"
+
+func TestInlineCSS(t *testing.T) {
+	tests := []struct {
+		name string
+		html string
+		want string
+	}{
+		{
+			name: "element rule",
+			html: `<style>p { color: red }</style><p>x</p>`,
+			want: `<p style="color: red">x</p>`,
+		},
+		{
+			name: "id beats element",
+			html: `<style>#x { color: blue } p { color: red }</style><p id="x">x</p>`,
+			want: `<p id="x" style="color: blue">x</p>`,
+		},
+		{
+			name: "class beats later element",
+			html: `<style>.a { color: red } p { color: blue }</style><p class="a">x</p>`,
+			want: `<p class="a" style="color: red">x</p>`,
+		},
+		{
+			name: "element and class beat class",
+			html: `<style>p.a { color: red } .a { color: blue }</style><p class="a">x</p>`,
+			want: `<p class="a" style="color: red">x</p>`,
+		},
+		{
+			name: "later rule wins at equal specificity",
+			html: `<style>p { color: red } p { color: blue }</style><p>x</p>`,
+			want: `<p style="color: blue">x</p>`,
+		},
+		{
+			name: "style attribute wins",
+			html: `<style>p { color: red; margin: 0 }</style><p style="color: green">x</p>`,
+			want: `<p style="color: green; margin: 0">x</p>`,
+		},
+		{
+			name: "selector lists",
+			html: `<style>.big, h1 { font-size: 20px }</style><h1>x</h1><span class="big">y</span>`,
+			want: `<h1 style="font-size: 20px">x</h1><span class="big" style="font-size: 20px">y</span>`,
+		},
+		{
+			name: "rules that can't be inlined are kept",
+			html: `<style>div p { color: red } @media (max-width: 600px) { p { color: black } } a:hover { color: green } p { margin: 0 }</style><p>x</p>`,
+			want: "<style>div p { color: red }\n@media (max-width: 600px) { p { color: black } }\na:hover { color: green }\n</style><p style=\"margin: 0\">x</p>",
+		},
+		{
+			name: "print styles are left alone",
+			html: `<style media="print">p { color: red }</style><p>x</p>`,
+			want: `<style media="print">p { color: red }</style><p>x</p>`,
+		},
+		{
+			name: "comments and quoted semicolons",
+			html: `<style>/* c */ td { background: url("a;b.png") }</style><td>x</td>`,
+			want: `<td style="background: url(&#34;a;b.png&#34;)">x</td>`,
+		},
+		{
+			name: "selectors and properties are case insensitive",
+			html: `<style>P { COLOR: red }</style><p>x</p>`,
+			want: `<p style="color: red">x</p>`,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			if got := InlineCSS(test.html); got != test.want {
+				t.Errorf("InlineCSS(%q)\n got %q\nwant %q", test.html, got, test.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,198 @@
+package messagingapi
+
+import (
+	"bytes"
+	"encoding/csv"
+	"fmt"
+	"reflect"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// CsvTimeLayout is the layout time.Time values are written with
+const CsvTimeLayout = "2006-01-02 15:04:05"
+
+// CsvReportBuilder builds a CsvReport row by row with encoding/csv, so
+// commas, quotes and line breaks in values are quoted. Each row is encoded
+// once into CsvReport.Lines, Report hands the lines over without copying
+type CsvReportBuilder struct {
+	filename string
+	columns  int
+	lines    []string
+	buffer   bytes.Buffer
+	writer   *csv.Writer
+}
+
+// NewCsvReportBuilder creates a builder for a report with the given
+// headers. Without headers, AddStructs writes them from the struct fields
+func NewCsvReportBuilder(filename string, headers ...string) *CsvReportBuilder {
+	b := &CsvReportBuilder{filename: filename}
+	b.writer = csv.NewWriter(&b.buffer)
+	if len(headers) > 0 {
+		b.columns = len(headers)
+		b.writeLine(headers)
+	}
+	return b
+}
+
+// AddRow adds a row of values. Strings, numbers, bools, time.Time,
+// fmt.Stringer, pointers to these and nil are supported, other values use
+// fmt.Sprint
+func (b *CsvReportBuilder) AddRow(values ...interface{}) error {
+	if b.columns == 0 {
+		b.columns = len(values)
+	}
+	if len(values) != b.columns {
+		return fmt.Errorf("Row has %d columns, expected %d", len(values), b.columns)
+	}
+
+	record := make([]string, len(values))
+	for i, value := range values {
+		record[i] = formatCsvValue(value)
+	}
+	return b.writeLine(record)
+}
+
+// AddStructs adds a row for every struct in a slice of structs or struct
+// pointers. Columns are the exported fields, named by a `csv:"Name"` tag
+// or the field name. Fields tagged `csv:"-"` are skipped
+func (b *CsvReportBuilder) AddStructs(rows interface{}) error {
+	value := reflect.ValueOf(rows)
+	if value.Kind() != reflect.Slice && value.Kind() != reflect.Array {
+		return fmt.Errorf("AddStructs requires a slice of structs, got %T", rows)
+	}
+	elemType := value.Type().Elem()
+	if elemType.Kind() == reflect.Ptr {
+		elemType = elemType.Elem()
+	}
+	if elemType.Kind() != reflect.Struct {
+		return fmt.Errorf("AddStructs requires a slice of structs, got %T", rows)
+	}
+
+	var fields []int
+	var headers []string
+	for i := 0; i < elemType.NumField(); i++ {
+		field := elemType.Field(i)
+		if field.PkgPath != "" {
+			continue
+		}
+		name := field.Tag.Get("csv")
+		if name == "-" {
+			continue
+		}
+		if name == "" {
+			name = field.Name
+		}
+		fields = append(fields, i)
+		headers = append(headers, name)
+	}
+	if len(fields) == 0 {
+		return fmt.Errorf("%s has no exported fields", elemType.Name())
+	}
+
+	if b.columns == 0 {
+		b.columns = len(headers)
+		err := b.writeLine(headers)
+		if err != nil {
+			return err
+		}
+	}
+	if len(fields) != b.columns {
+		return fmt.Errorf("%s has %d columns, expected %d", elemType.Name(), len(fields), b.columns)
+	}
+
+	record := make([]string, len(fields))
+	for row := 0; row < value.Len(); row++ {
+		item := value.Index(row)
+		if item.Kind() == reflect.Ptr {
+			if item.IsNil() {
+				return fmt.Errorf("Row %d is nil", row)
+			}
+			item = item.Elem()
+		}
+		for i, field := range fields {
+			record[i] = formatCsvValue(item.Field(field).Interface())
+		}
+		err := b.writeLine(record)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Rows returns the amount of lines in the report, including the headers
+func (b *CsvReportBuilder) Rows() int {
+	return len(b.lines)
+}
+
+// Report returns the report and empties the builder
+func (b *CsvReportBuilder) Report() CsvReport {
+	report := CsvReport{Filename: b.filename, Lines: b.lines}
+	b.lines = nil
+	b.columns = 0
+	return report
+}
+
+// writeLine encodes a record into a line of the report
+func (b *CsvReportBuilder) writeLine(record []string) error {
+	b.buffer.Reset()
+	err := b.writer.Write(record)
+	if err != nil {
+		return err
+	}
+	b.writer.Flush()
+	if err = b.writer.Error(); err != nil {
+		return err
+	}
+	b.lines = append(b.lines, strings.TrimSuffix(b.buffer.String(), "\n"))
+	return nil
+}
+
+// formatCsvValue formats a value for a report column
+func formatCsvValue(value interface{}) string {
+	ptr := reflect.ValueOf(value)
+	if ptr.Kind() == reflect.Ptr {
+		if ptr.IsNil() {
+			return ""
+		}
+		// Format the value pointed to, unless only the pointer has a
+		// String method
+		elem := ptr.Elem().Interface()
+		_, pointerStringer := value.(fmt.Stringer)
+		_, elemStringer := elem.(fmt.Stringer)
+		if !pointerStringer || elemStringer {
+			return formatCsvValue(elem)
+		}
+	}
+
+	switch v := value.(type) {
+	case nil:
+		return ""
+	case string:
+		return v
+	case []byte:
+		return string(v)
+	case bool:
+		return strconv.FormatBool(v)
+	case int:
+		return strconv.Itoa(v)
+	case int8, int16, int32, int64:
+		return strconv.FormatInt(reflect.ValueOf(v).Int(), 10)
+	case uint, uint8, uint16, uint32, uint64:
+		return strconv.FormatUint(reflect.ValueOf(v).Uint(), 10)
+	case float32:
+		return strconv.FormatFloat(float64(v), 'f', -1, 32)
+	case float64:
+		return strconv.FormatFloat(v, 'f', -1, 64)
+	case time.Time:
+		if v.IsZero() {
+			return ""
+		}
+		return v.Format(CsvTimeLayout)
+	case fmt.Stringer:
+		return v.String()
+	}
+	return fmt.Sprint(value)
+}
@@ -0,0 +1,183 @@
+package messagingapi
+
+import (
+	"bufio"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// Email attachment dispositions
+const (
+	// The attachment is shown as a file to download
+	EmailDispositionAttachment = "attachment"
+	// The attachment is shown in the HTML body, referenced as cid:ContentID
+	EmailDispositionInline = "inline"
+)
+
+// DefaultEmailAttachmentLimit is the default maximum total decoded size
+// in bytes of the attachments of an email
+const DefaultEmailAttachmentLimit = 10 * 1024 * 1024
+
+// attachmentLimit returns the maximum total decoded size in bytes of the
+// attachments of an email
+func (config APIConfig) attachmentLimit() int {
+	if config.EmailAttachmentLimit <= 0 {
+		return DefaultEmailAttachmentLimit
+	}
+	return config.EmailAttachmentLimit
+}
+
+// NewEmailAttachment reads r into an attachment named filename, base64
+// encoding it as it is read. The content type is sniffed from the content,
+// falling back to the extension of filename. Reading stops with an error
+// once the content is over DefaultEmailAttachmentLimit
+func NewEmailAttachment(r io.Reader, filename string) (EmailAttachment, error) {
+	return newEmailAttachment(r, filename, DefaultEmailAttachmentLimit)
+}
+
+// NewEmailAttachmentFromFile reads the file at path into an attachment
+// named after the file, see NewEmailAttachment
+func NewEmailAttachmentFromFile(path string) (EmailAttachment, error) {
+	return newEmailAttachmentFromFile(path, DefaultEmailAttachmentLimit)
+}
+
+// NewEmailAttachment reads r into an attachment like the NewEmailAttachment
+// function, limited to the EmailAttachmentLimit of the APIConfig
+func (api *MessagingAPI) NewEmailAttachment(r io.Reader, filename string) (EmailAttachment, error) {
+	return newEmailAttachment(r, filename, api.config.attachmentLimit())
+}
+
+// NewEmailAttachmentFromFile reads the file at path into an attachment,
+// limited to the EmailAttachmentLimit of the APIConfig
+func (api *MessagingAPI) NewEmailAttachmentFromFile(path string) (EmailAttachment, error) {
+	return newEmailAttachmentFromFile(path, api.config.attachmentLimit())
+}
+
+// newEmailAttachment reads at most limit bytes of r into an attachment
+func newEmailAttachment(r io.Reader, filename string, limit int) (EmailAttachment, error) {
+	if filename == "" {
+		return EmailAttachment{}, errors.New("Attachment filename can not be blank")
+	}
+
+	// Read one byte past the limit to tell a full read from a cut off one
+	reader := bufio.NewReaderSize(io.LimitReader(r, int64(limit)+1), 512)
+	head, err := reader.Peek(512)
+	if err != nil && err != io.EOF && err != bufio.ErrBufferFull {
+		return EmailAttachment{}, err
+	}
+	if len(head) == 0 {
+		return EmailAttachment{}, fmt.Errorf("Attachment %s is empty", filename)
+	}
+	contentType := SniffMIMEType(head, filename)
+
+	var data strings.Builder
+	encoder := base64.NewEncoder(base64.StdEncoding, &data)
+	read, err := io.Copy(encoder, reader)
+	if err == nil && read > int64(limit) {
+		err = fmt.Errorf("Attachment %s is over the %d byte limit", filename, limit)
+	}
+	if err == nil {
+		err = encoder.Close()
+	}
+	if err != nil {
+		return EmailAttachment{}, err
+	}
+
+	return EmailAttachment{
+		Filename:    filename,
+		Data:        data.String(),
+		ContentType: contentType,
+		Disposition: EmailDispositionAttachment,
+	}, nil
+}
+
+// newEmailAttachmentFromFile reads the file at path into an attachment,
+// rejecting files over limit before reading them
+func newEmailAttachmentFromFile(path string, limit int) (EmailAttachment, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return EmailAttachment{}, err
+	}
+	defer file.Close()
+
+	filename := filepath.Base(path)
+	if info, err := file.Stat(); err == nil && info.Mode().IsRegular() && info.Size() > int64(limit) {
+		return EmailAttachment{}, fmt.Errorf("Attachment %s is over the %d byte limit", filename, limit)
+	}
+	return newEmailAttachment(file, filename, limit)
+}
+
+// Inline returns the attachment shown inline in the HTML body, where it
+// is referenced as src="cid:contentID". Only images can be inline
+func (attachment EmailAttachment) Inline(contentID string) EmailAttachment {
+	attachment.Disposition = EmailDispositionInline
+	attachment.ContentID = strings.Trim(contentID, "<>")
+	return attachment
+}
+
+// CID returns the URL the HTML body references an inline attachment by
+func (attachment EmailAttachment) CID() string {
+	return "cid:" + attachment.ContentID
+}
+
+// DecodedSize returns the size of the attachment once decoded
+func (attachment EmailAttachment) DecodedSize() int {
+	return base64DecodedSize(attachment.Data)
+}
+
+// AttachmentSize returns the total decoded size of the attachments
+func (data SubmitEmailMessageData) AttachmentSize() int {
+	size := 0
+	for _, attachment := range data.Attachments {
+		size += attachment.DecodedSize()
+	}
+	return size
+}
+
+// validateAttachments checks the attachments fit the size limit, and that
+// inline attachments are images with a unique content ID referenced from
+// the HTML body
+func (data SubmitEmailMessageData) validateAttachments(limit int) error {
+	contentIDs := make(map[string]bool)
+	for _, attachment := range data.Attachments {
+		if attachment.Filename == "" {
+			return errors.New("Attachment filename can not be blank")
+		}
+		if attachment.Data == "" {
+			return fmt.Errorf("Attachment %s is empty", attachment.Filename)
+		}
+
+		switch attachment.Disposition {
+		case "", EmailDispositionAttachment:
+		case EmailDispositionInline:
+			if attachment.ContentID == "" {
+				return fmt.Errorf("Inline attachment %s must have a ContentID", attachment.Filename)
+			}
+			if strings.ContainsAny(attachment.ContentID, " <>\"\r\n") {
+				return fmt.Errorf("Inline attachment %s has an invalid ContentID %s", attachment.Filename, attachment.ContentID)
+			}
+			if contentIDs[attachment.ContentID] {
+				return fmt.Errorf("Inline attachment ContentID %s is used more than once", attachment.ContentID)
+			}
+			contentIDs[attachment.ContentID] = true
+			if attachment.ContentType != "" && !strings.HasPrefix(attachment.ContentType, "image/") {
+				return fmt.Errorf("Inline attachment %s must be an image, not %s", attachment.Filename, attachment.ContentType)
+			}
+			if !strings.Contains(data.HTML, attachment.CID()) {
+				return fmt.Errorf("Inline attachment %s is not referenced as %s in the HTML", attachment.Filename, attachment.CID())
+			}
+		default:
+			return fmt.Errorf("Attachment %s has an invalid disposition %s", attachment.Filename, attachment.Disposition)
+		}
+	}
+
+	if size := data.AttachmentSize(); size > limit {
+		return fmt.Errorf("Email attachments are %d bytes, over the %d byte limit", size, limit)
+	}
+	return nil
+}
@@ -0,0 +1,144 @@
+package messagingapi
+
+import (
+	"errors"
+	"fmt"
+	"net/mail"
+	"strings"
+)
+
+// DefaultEmailMaxRecipients is the amount of Address recipients the API
+// accepts per email. Use CreateFanOut to send to more
+const DefaultEmailMaxRecipients = 1
+
+// maxRecipients returns the amount of Address recipients accepted per
+// email
+func (config APIConfig) maxRecipients() int {
+	if config.EmailMaxRecipients <= 0 {
+		return DefaultEmailMaxRecipients
+	}
+	return config.EmailMaxRecipients
+}
+
+// ParseEmailAddress parses an RFC 5322 address such as
+// "Jane Doe <jane@example.com>" or "jane@example.com"
+func ParseEmailAddress(address string) (*mail.Address, error) {
+	parsed, err := mail.ParseAddress(strings.TrimSpace(address))
+	if err != nil {
+		return nil, fmt.Errorf("Invalid email address %q: %s", address, err.Error())
+	}
+	return parsed, nil
+}
+
+// validateRecipients checks the syntax of every address and that there
+// are at most max Address recipients
+func (data SubmitEmailMessageData) validateRecipients(max int) error {
+	if len(data.Address) == 0 {
+		return errors.New("Email messages must have at least one recipient listed in Address")
+	}
+	if len(data.Address) > max {
+		return fmt.Errorf("Email messages can list at most %d Address recipients, use CreateFanOut to send to more", max)
+	}
+
+	fields := []struct {
+		name      string
+		addresses []string
+	}{
+		{"Address", data.Address},
+		{"CC", data.CC},
+		{"BCC", data.BCC},
+	}
+	for _, field := range fields {
+		for _, address := range field.addresses {
+			if _, err := ParseEmailAddress(address); err != nil {
+				return fmt.Errorf("%s: %s", field.name, err.Error())
+			}
+		}
+	}
+	if data.ReplyTo != "" {
+		if _, err := ParseEmailAddress(data.ReplyTo); err != nil {
+			return fmt.Errorf("ReplyTo: %s", err.Error())
+		}
+	}
+	return nil
+}
+
+// FanOutResult is the outcome of sending the copy of an email to one
+// recipient with CreateFanOut
+type FanOutResult struct {
+	// Address is the recipient as listed in the message
+	Address string
+	// MessageID assigned by the API, blank if the send failed
+	MessageID string
+	Result    APIResult
+	Err       error
+}
+
+// CreateFanOut sends an email to every recipient in Address, CC and BCC
+// as a separate message with a single recipient, for when the API only
+// accepts one recipient per email. CC and BCC are not set on the copies,
+// so BCC recipients stay hidden. The results are keyed by the lowercase
+// email address of every recipient, duplicates are sent once. An idempotency
+// key on the message is suffixed with the address for every copy
+func (api *MessagingAPI) CreateFanOut(message NewMessage) (map[string]FanOutResult, error) {
+	if message.Action != APIActionTypesSubmitEmail {
+		return nil, errors.New("CreateFanOut requires the SubmitEmail action")
+	}
+	data, ok := message.Data.(SubmitEmailMessageData)
+	if !ok {
+		return nil, errors.New("CreateFanOut requires Data to be of type SubmitEmailMessageData")
+	}
+
+	var recipients []string
+	recipients = append(recipients, data.Address...)
+	recipients = append(recipients, data.CC...)
+	recipients = append(recipients, data.BCC...)
+
+	// Validate every copy before sending any
+	var messages []NewMessage
+	var keys []string
+	seen := make(map[string]bool)
+	for _, recipient := range recipients {
+		parsed, err := ParseEmailAddress(recipient)
+		if err != nil {
+			return nil, err
+		}
+		key := strings.ToLower(parsed.Address)
+		if seen[key] {
+			continue
+		}
+		seen[key] = true
+
+		copyData := data
+		copyData.Address = []string{recipient}
+		copyData.CC = nil
+		copyData.BCC = nil
+		copyMessage := message
+		copyMessage.Data = copyData
+		if message.IdempotencyKey != "" {
+			copyMessage.IdempotencyKey = message.IdempotencyKey + ":" + key
+		}
+		copyMessage.prepare()
+		err = copyMessage.validate(api.config)
+		if err != nil {
+			return nil, err
+		}
+		messages = append(messages, copyMessage)
+		keys = append(keys, key)
+	}
+	if len(messages) == 0 {
+		return nil, errors.New("Email messages must have at least one recipient listed in Address")
+	}
+
+	results := make(map[string]FanOutResult, len(messages))
+	for i, copyMessage := range messages {
+		result, err := api.Create(copyMessage)
+		results[keys[i]] = FanOutResult{
+			Address:   copyMessage.Data.(SubmitEmailMessageData).Address[0],
+			MessageID: result.MessageResult.MessageID,
+			Result:    result,
+			Err:       err,
+		}
+	}
+	return results, nil
+}
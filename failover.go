@@ -0,0 +1,277 @@
+package messagingapi
+
+import (
+	"errors"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+)
+
+// EndpointStatus reports the health of an endpoint
+type EndpointStatus struct {
+	// Endpoint is the endpoint URL
+	Endpoint string
+	// Active is true for the endpoint requests are currently sent to
+	Active bool
+	// Healthy is false while the endpoint's breaker is open
+	Healthy bool
+	// ConsecutiveFailures is the amount of failed calls and health checks
+	// since the last successful one
+	ConsecutiveFailures int
+	// LastError is the error of the last failed call or health check
+	LastError string
+	// LastCheck is when the endpoint was last health checked
+	LastCheck time.Time
+}
+
+// endpointPool fails over between an ordered list of endpoints. Each
+// endpoint has a breaker that opens after a run of failed calls and health
+// checks, and is closed again by a successful one. Requests go to the first
+// healthy endpoint, so traffic fails back once a preferred one recovers
+type endpointPool struct {
+	lock      sync.Mutex
+	endpoints []*EndpointStatus
+	threshold int
+	stop      chan struct{}
+	stopOnce  sync.Once
+}
+
+// newEndpointPool creates a pool for the endpoints, which must already
+// end with a slash. Health checks run until close, a negative interval
+// disables them
+func newEndpointPool(config APIConfig, endpoints []string) *endpointPool {
+	threshold := config.FailoverThreshold
+	if threshold <= 0 {
+		threshold = 3
+	}
+
+	pool := endpointPool{
+		threshold: threshold,
+		stop:      make(chan struct{}),
+	}
+	for _, endpoint := range endpoints {
+		pool.endpoints = append(pool.endpoints, &EndpointStatus{
+			Endpoint: endpoint,
+			Healthy:  true,
+		})
+	}
+
+	interval := config.HealthCheckInterval
+	if interval == 0 {
+		interval = 30 * time.Second
+	}
+	if interval > 0 {
+		go pool.healthCheck(config, interval)
+	}
+
+	return &pool
+}
+
+// active returns the index of the first healthy endpoint, falling back
+// to the preferred endpoint when none are healthy
+func (p *endpointPool) active() int {
+	for i, endpoint := range p.endpoints {
+		if endpoint.Healthy {
+			return i
+		}
+	}
+	return 0
+}
+
+// candidates returns the endpoints to try in order, healthy ones first
+func (p *endpointPool) candidates() []string {
+	p.lock.Lock()
+	defer p.lock.Unlock()
+
+	var healthy, unhealthy []string
+	for _, endpoint := range p.endpoints {
+		if endpoint.Healthy {
+			healthy = append(healthy, endpoint.Endpoint)
+		} else {
+			unhealthy = append(unhealthy, endpoint.Endpoint)
+		}
+	}
+	return append(healthy, unhealthy...)
+}
+
+// record updates the breaker of an endpoint after a call
+func (p *endpointPool) record(endpoint string, statusCode int, err error) {
+	p.lock.Lock()
+	defer p.lock.Unlock()
+
+	for _, status := range p.endpoints {
+		if status.Endpoint == endpoint {
+			p.observe(status, statusCode, err)
+			return
+		}
+	}
+}
+
+// observe counts the outcome of a call or health check against the
+// endpoint, it is marked unhealthy after threshold failures in a row. The
+// caller must hold the lock
+func (p *endpointPool) observe(status *EndpointStatus, statusCode int, err error) {
+	if !endpointFailed(statusCode, err) {
+		status.Healthy = true
+		status.ConsecutiveFailures = 0
+		return
+	}
+	status.ConsecutiveFailures++
+	status.LastError = err.Error()
+	if status.ConsecutiveFailures >= p.threshold {
+		status.Healthy = false
+	}
+}
+
+// status returns a copy of the state of all endpoints
+func (p *endpointPool) status() []EndpointStatus {
+	p.lock.Lock()
+	defer p.lock.Unlock()
+
+	active := p.active()
+	statuses := make([]EndpointStatus, len(p.endpoints))
+	for i, endpoint := range p.endpoints {
+		statuses[i] = *endpoint
+		statuses[i].Active = i == active
+	}
+	return statuses
+}
+
+// activeEndpoint returns the endpoint requests are currently sent to
+func (p *endpointPool) activeEndpoint() string {
+	p.lock.Lock()
+	defer p.lock.Unlock()
+	return p.endpoints[p.active()].Endpoint
+}
+
+// healthCheck pings every endpoint each interval until close is called
+func (p *endpointPool) healthCheck(config APIConfig, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-p.stop:
+			return
+		case <-ticker.C:
+		}
+
+		for _, endpoint := range p.candidates() {
+			statusCode, err := pingEndpoint(config, endpoint)
+
+			p.lock.Lock()
+			for _, status := range p.endpoints {
+				if status.Endpoint != endpoint {
+					continue
+				}
+				status.LastCheck = time.Now()
+				p.observe(status, statusCode, err)
+			}
+			p.lock.Unlock()
+		}
+	}
+}
+
+// close stops the health checks
+func (p *endpointPool) close() {
+	p.stopOnce.Do(func() {
+		close(p.stop)
+	})
+}
+
+// middleware sends each request to the active endpoint. GET requests and
+// requests with an idempotency key are tried against the next endpoint
+// when an endpoint fails, other requests fail over on the next call
+func (p *endpointPool) middleware(next RequestHandler) RequestHandler {
+	return func(r *APIWebRequest) (string, int, error) {
+		candidates := p.candidates()
+		failover := r.Method == "GET" || r.Header.Get(IdempotencyKeyHeader) != ""
+
+		var body string
+		var statusCode int
+		var err error
+		for i, endpoint := range candidates {
+			r.config.Endpoint = endpoint
+			r.Info.Endpoint = endpoint
+			body, statusCode, err = next(r)
+			p.record(endpoint, statusCode, err)
+			if !endpointFailed(statusCode, err) || !failover || i == len(candidates)-1 {
+				break
+			}
+		}
+		return body, statusCode, err
+	}
+}
+
+// endpointFailed returns true if a call failed because of the endpoint,
+// rather than because of the request
+func endpointFailed(statusCode int, err error) bool {
+	if err == nil {
+		return false
+	}
+	return statusCode == 0 || statusCode >= http.StatusInternalServerError
+}
+
+// pingEndpoint calls the ping route of a single endpoint
+func pingEndpoint(config APIConfig, endpoint string) (int, error) {
+	pingConfig := APIConfig{
+		Endpoint:    endpoint,
+		AccessToken: config.AccessToken,
+		TokenSource: config.TokenSource,
+	}
+	r, err := NewAPIWebRequest(pingConfig, "ping", "GET", "")
+	if err != nil {
+		return 0, err
+	}
+	_, statusCode, err := r.Execute()
+	return statusCode, err
+}
+
+// normalizeEndpoints adds trailing slashes and drops blank endpoints
+func normalizeEndpoints(endpoints []string) ([]string, error) {
+	var normalized []string
+	for _, endpoint := range endpoints {
+		endpoint = strings.TrimSpace(endpoint)
+		if endpoint == "" {
+			continue
+		}
+		if !strings.HasSuffix(endpoint, "/") {
+			endpoint = endpoint + "/"
+		}
+		normalized = append(normalized, endpoint)
+	}
+	if len(normalized) == 0 {
+		return nil, errors.New("Endpoint can not be blank")
+	}
+	return normalized, nil
+}
+
+// ActiveEndpoint returns the endpoint requests are currently sent to
+func (api *MessagingAPI) ActiveEndpoint() string {
+	if api.config.pool == nil {
+		return api.config.Endpoint
+	}
+	return api.config.pool.activeEndpoint()
+}
+
+// EndpointStatus returns the health of every configured endpoint, in
+// order of preference
+func (api *MessagingAPI) EndpointStatus() []EndpointStatus {
+	if api.config.pool == nil {
+		return []EndpointStatus{{
+			Endpoint: api.config.Endpoint,
+			Active:   true,
+			Healthy:  true,
+		}}
+	}
+	return api.config.pool.status()
+}
+
+// Close stops the background health checks. Unhealthy endpoints are then
+// only brought back by a successful request
+func (api *MessagingAPI) Close() {
+	if api.config.pool != nil {
+		api.config.pool.close()
+	}
+}
@@ -0,0 +1,356 @@
+package messagingapi
+
+import (
+	"html"
+	"strconv"
+	"strings"
+)
+
+// htmlSkippedElements are not rendered as text
+var htmlSkippedElements = map[string]bool{
+	"head":     true,
+	"script":   true,
+	"style":    true,
+	"title":    true,
+	"template": true,
+}
+
+// htmlParagraphElements are separated from the surrounding text by a
+// blank line
+var htmlParagraphElements = map[string]bool{
+	"p":          true,
+	"h1":         true,
+	"h2":         true,
+	"h3":         true,
+	"h4":         true,
+	"h5":         true,
+	"h6":         true,
+	"blockquote": true,
+	"pre":        true,
+	"table":      true,
+	"ul":         true,
+	"ol":         true,
+	"dl":         true,
+}
+
+// htmlBlockElements start on a new line
+var htmlBlockElements = map[string]bool{
+	"div":        true,
+	"section":    true,
+	"article":    true,
+	"header":     true,
+	"footer":     true,
+	"nav":        true,
+	"aside":      true,
+	"main":       true,
+	"center":     true,
+	"address":    true,
+	"form":       true,
+	"figure":     true,
+	"figcaption": true,
+	"tr":         true,
+	"dt":         true,
+	"dd":         true,
+	"caption":    true,
+	"tbody":      true,
+	"thead":      true,
+	"tfoot":      true,
+}
+
+// HTMLToText renders an HTML email as readable plain text. Scripts and
+// styles are stripped, tables are flattened to a line per row with cells
+// separated by " | ", list items are bulleted or numbered, images are
+// replaced by their alt text and links are rendered as numbered footnotes
+func HTMLToText(source string) string {
+	r := textRenderer{linkNumbers: make(map[string]int)}
+	for _, token := range tokenizeHTML(source) {
+		switch token.kind {
+		case htmlText:
+			if r.skip == 0 {
+				r.text(html.UnescapeString(token.raw))
+			}
+		case htmlStartTag:
+			r.startTag(token)
+		case htmlEndTag:
+			r.endTag(token.name)
+		}
+	}
+	return r.String()
+}
+
+// textRenderer builds the plain text of an HTML document
+type textRenderer struct {
+	out strings.Builder
+	// skip is the depth of skipped elements the renderer is in
+	skip int
+	pre  int
+	// spacePending is set when whitespace was collapsed
+	spacePending bool
+	// lists holds the next item number of every open list, zero for
+	// unordered lists
+	lists []int
+	// cellPending is set when a cell separator must precede the next text
+	cellPending bool
+	rowHasText  bool
+	// anchors holds the href and output offset of every open link
+	anchors     []textAnchor
+	links       []string
+	linkNumbers map[string]int
+}
+
+// textAnchor is an open link
+type textAnchor struct {
+	href  string
+	start int
+}
+
+// startTag renders the start of an element
+func (r *textRenderer) startTag(token htmlToken) {
+	if htmlSkippedElements[token.name] {
+		if !token.selfClosing {
+			r.skip++
+		}
+		return
+	}
+	if r.skip > 0 {
+		return
+	}
+
+	switch {
+	case token.name == "br":
+		r.newline(1)
+	case token.name == "hr":
+		r.newline(1)
+		r.write("----------")
+		r.newline(1)
+	case token.name == "img":
+		if alt, ok := token.attr("alt"); ok {
+			r.text(alt)
+		}
+	case token.name == "a":
+		href, _ := token.attr("href")
+		r.anchors = append(r.anchors, textAnchor{href: strings.TrimSpace(href), start: r.out.Len()})
+	case token.name == "li":
+		r.newline(1)
+		if len(r.lists) > 0 {
+			r.write(strings.Repeat("  ", len(r.lists)-1))
+			if number := r.lists[len(r.lists)-1]; number > 0 {
+				r.write(strconv.Itoa(number) + ". ")
+				r.lists[len(r.lists)-1]++
+				return
+			}
+		}
+		r.write("- ")
+	case token.name == "td" || token.name == "th":
+		if r.rowHasText {
+			r.cellPending = true
+		}
+	case token.name == "tr":
+		r.newline(1)
+		r.rowHasText = false
+		r.cellPending = false
+	case htmlParagraphElements[token.name]:
+		if token.name == "ul" || token.name == "ol" {
+			// Nested lists continue their parent item
+			if len(r.lists) > 0 {
+				r.newline(1)
+			} else {
+				r.newline(2)
+			}
+			number := 0
+			if token.name == "ol" {
+				number = 1
+				if start, err := strconv.Atoi(attrOrBlank(token, "start")); err == nil {
+					number = start
+				}
+			}
+			r.lists = append(r.lists, number)
+			return
+		}
+		r.newline(2)
+		if token.name == "pre" {
+			r.pre++
+		}
+	case htmlBlockElements[token.name]:
+		r.newline(1)
+	}
+}
+
+// endTag renders the end of an element
+func (r *textRenderer) endTag(name string) {
+	if htmlSkippedElements[name] {
+		if r.skip > 0 {
+			r.skip--
+		}
+		return
+	}
+	if r.skip > 0 {
+		return
+	}
+
+	switch {
+	case name == "a":
+		if len(r.anchors) == 0 {
+			return
+		}
+		anchor := r.anchors[len(r.anchors)-1]
+		r.anchors = r.anchors[:len(r.anchors)-1]
+		r.footnote(anchor)
+	case name == "tr":
+		r.newline(1)
+		r.rowHasText = false
+		r.cellPending = false
+	case name == "ul" || name == "ol":
+		if len(r.lists) > 0 {
+			r.lists = r.lists[:len(r.lists)-1]
+		}
+		if len(r.lists) == 0 {
+			r.newline(2)
+		} else {
+			r.newline(1)
+		}
+	case htmlParagraphElements[name]:
+		if name == "pre" && r.pre > 0 {
+			r.pre--
+		}
+		r.newline(2)
+	case htmlBlockElements[name] || name == "li":
+		r.newline(1)
+	}
+}
+
+// footnote adds a reference to the link after its text
+func (r *textRenderer) footnote(anchor textAnchor) {
+	href := anchor.href
+	if href == "" || strings.HasPrefix(href, "#") || strings.HasPrefix(strings.ToLower(href), "javascript:") {
+		return
+	}
+	text := strings.TrimSpace(r.out.String()[anchor.start:])
+	target := strings.TrimPrefix(href, "mailto:")
+	if text == href || text == target {
+		return
+	}
+
+	number, ok := r.linkNumbers[href]
+	if !ok {
+		r.links = append(r.links, target)
+		number = len(r.links)
+		r.linkNumbers[href] = number
+	}
+	r.spacePending = true
+	r.write("[" + strconv.Itoa(number) + "]")
+}
+
+// text renders text content, collapsing whitespace outside of pre
+func (r *textRenderer) text(text string) {
+	if text == "" {
+		return
+	}
+	if r.pre > 0 {
+		r.separate()
+		r.out.WriteString(text)
+		return
+	}
+
+	words := strings.Fields(text)
+	if len(words) == 0 {
+		r.spacePending = true
+		return
+	}
+	if isHTMLSpace(text[0]) {
+		r.spacePending = true
+	}
+	r.separate()
+	r.out.WriteString(strings.Join(words, " "))
+	r.spacePending = isHTMLSpace(text[len(text)-1])
+}
+
+// write renders generated text such as bullets
+func (r *textRenderer) write(text string) {
+	r.separate()
+	r.out.WriteString(text)
+}
+
+// separate writes a pending cell separator or collapsed space
+func (r *textRenderer) separate() {
+	if r.cellPending {
+		r.out.WriteString(" | ")
+		r.cellPending = false
+		r.spacePending = false
+	}
+	if r.spacePending && !r.atLineStart() {
+		r.out.WriteByte(' ')
+	}
+	r.spacePending = false
+	r.rowHasText = true
+}
+
+// newline ends the current line, making sure the output ends with at
+// least count line breaks. Nothing is written at the start of the output
+func (r *textRenderer) newline(count int) {
+	r.spacePending = false
+	out := r.out.String()
+	if out == "" {
+		return
+	}
+	trailing := len(out) - len(strings.TrimRight(out, "\n"))
+	for ; trailing < count; trailing++ {
+		r.out.WriteByte('\n')
+	}
+}
+
+// atLineStart returns true at the start of the output or of a line
+func (r *textRenderer) atLineStart() bool {
+	out := r.out.String()
+	return out == "" || out[len(out)-1] == '\n'
+}
+
+// String returns the text with trailing spaces removed from every line,
+// at most one blank line in a row, and the link footnotes appended
+func (r *textRenderer) String() string {
+	lines := strings.Split(r.out.String(), "\n")
+	var out []string
+	blank := 0
+	for _, line := range lines {
+		line = strings.TrimRight(line, " \t")
+		if line == "" {
+			blank++
+			if blank > 1 {
+				continue
+			}
+		} else {
+			blank = 0
+		}
+		out = append(out, line)
+	}
+	text := strings.TrimSpace(strings.Join(out, "\n"))
+
+	if len(r.links) > 0 {
+		text += "\n\n"
+		for i, link := range r.links {
+			text += "[" + strconv.Itoa(i+1) + "] " + link + "\n"
+		}
+		text = strings.TrimRight(text, "\n")
+	}
+	return text
+}
+
+// attrOrBlank returns the named attribute, blank if missing
+func attrOrBlank(token htmlToken, name string) string {
+	value, _ := token.attr(name)
+	return value
+}
+
+// prepare applies the HTML options to the message data
+func (data SubmitEmailMessageData) prepare() SubmitEmailMessageData {
+	if data.HTML == "" {
+		return data
+	}
+	if data.InlineCSS {
+		data.HTML = InlineCSS(data.HTML)
+	}
+	if data.GenerateText && strings.TrimSpace(data.Text) == "" {
+		data.Text = HTMLToText(data.HTML)
+	}
+	return data
+}
@@ -0,0 +1,98 @@
+package messagingapi
+
+import (
+	"strings"
+	"testing"
+	"unicode/utf8"
+)
+
+func TestHTMLToText(t *testing.T) {
+	tests := []struct {
+		name string
+		html string
+		want string
+	}{
+		{
+			name: "table cells are joined",
+			html: `<table><tr><td>a</td><td>b</td></tr></table>`,
+			want: "a | b",
+		},
+		{
+			name: "empty table cells are skipped",
+			html: `<table><tr><th>Item</th><th></th><th>Price</th></tr><tr><td>Tea</td><td> </td><td>R10</td></tr></table>`,
+			want: "Item | Price\nTea | R10",
+		},
+		{
+			name: "links become numbered footnotes",
+			html: `<p>Visit <a href="https://example.com/a">our site</a> or <a href="https://example.com/a">again</a>.</p><p><a href="https://example.com/b">b</a></p>`,
+			want: "Visit our site [1] or again [1].\n\nb [2]\n\n[1] https://example.com/a\n[2] https://example.com/b",
+		},
+		{
+			name: "links showing their URL have no footnote",
+			html: `<a href="https://example.com">https://example.com</a>`,
+			want: "https://example.com",
+		},
+		{
+			name: "mailto links show the address and fragment links are dropped",
+			html: `<a href="mailto:a@b.c">mail us</a> <a href="#top">top</a>`,
+			want: "mail us [1] top\n\n[1] a@b.c",
+		},
+		{
+			name: "scripts and styles are dropped",
+			html: `<h1>Hi &amp; bye</h1><script>alert("<p>x</p>")</script><style>p{}</style><p>One<br>Two</p>`,
+			want: "Hi & bye\n\nOne\nTwo",
+		},
+		{
+			name: "nested lists are indented",
+			html: `<ul><li>One</li><li>Two<ol><li>Sub</li><li>Sub2</li></ol></li></ul>`,
+			want: "- One\n- Two\n  1. Sub\n  2. Sub2",
+		},
+		{
+			name: "images show their alt text",
+			html: `<img src="x.png" alt="Logo"> <img src="y.png">`,
+			want: "Logo",
+		},
+		{
+			name: "entities are decoded",
+			html: `Tom &lt;tom@x&gt; &#65;&#x42; &bogus;`,
+			want: "Tom <tom@x> AB &bogus;",
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			if got := HTMLToText(test.html); got != test.want {
+				t.Errorf("HTMLToText(%q)\n got %q\nwant %q", test.html, got, test.want)
+			}
+		})
+	}
+}
+
+func FuzzHTMLToText(f *testing.F) {
+	seeds := []string{
+		`<table><tr><td>a</td><td>b</td></tr></table>`,
+		`<p>Visit <a href="https://example.com/a">our site</a></p>`,
+		`<ul><li>One<ol><li>Sub</li></ol></li></ul>`,
+		`<pre>  a
+  b</pre>`,
+		`<script>if (a < b) { alert("<p>x</p>") }</script>`,
+		`<style>p { color: red } @media (max-width: 600px) { p { color: black } }</style><p class="a" style="margin: 0">x</p>`,
+		`<!-- <p>comment</p> --><p>&amp;&#x41;&#66;&nbsp;`,
+		`<a href="x"><img alt="y"`,
+		`<<>>&;</`,
+	}
+	for _, seed := range seeds {
+		f.Add(seed)
+	}
+
+	f.Fuzz(func(t *testing.T, html string) {
+		text := HTMLToText(html)
+		if utf8.ValidString(html) && !utf8.ValidString(text) {
+			t.Errorf("HTMLToText(%q) returned invalid UTF-8 %q", html, text)
+		}
+		if strings.HasSuffix(text, "\n") {
+			t.Errorf("HTMLToText(%q) has a trailing line break", html)
+		}
+		InlineCSS(html)
+	})
+}
@@ -0,0 +1,256 @@
+package messagingapi
+
+import (
+	"html"
+	"strings"
+)
+
+// Kinds of htmlToken
+const (
+	htmlText = iota
+	htmlStartTag
+	htmlEndTag
+	// Comments, doctypes and processing instructions
+	htmlOther
+)
+
+// htmlAttr is an attribute of a start tag, the value is unescaped
+type htmlAttr struct {
+	name  string
+	value string
+}
+
+// htmlToken is a piece of an HTML document. Raw is the source it was
+// read from, so unchanged tokens can be written back as is
+type htmlToken struct {
+	kind        int
+	name        string
+	attrs       []htmlAttr
+	selfClosing bool
+	raw         string
+}
+
+// attr returns the value of the named attribute
+func (t htmlToken) attr(name string) (string, bool) {
+	for _, attr := range t.attrs {
+		if attr.name == name {
+			return attr.value, true
+		}
+	}
+	return "", false
+}
+
+// setAttr sets the named attribute, adding it if missing, and rebuilds raw
+func (t *htmlToken) setAttr(name string, value string) {
+	found := false
+	for i := range t.attrs {
+		if t.attrs[i].name == name {
+			t.attrs[i].value = value
+			found = true
+		}
+	}
+	if !found {
+		t.attrs = append(t.attrs, htmlAttr{name: name, value: value})
+	}
+
+	var raw strings.Builder
+	raw.WriteString("<" + t.name)
+	for _, attr := range t.attrs {
+		raw.WriteString(" " + attr.name + "=\"" + html.EscapeString(attr.value) + "\"")
+	}
+	if t.selfClosing {
+		raw.WriteString(" /")
+	}
+	raw.WriteString(">")
+	t.raw = raw.String()
+}
+
+// htmlRawTextElements hold text which isn't parsed as HTML
+var htmlRawTextElements = map[string]bool{
+	"script":   true,
+	"style":    true,
+	"textarea": true,
+	"title":    true,
+}
+
+// tokenizeHTML splits an HTML document into tokens. It is lenient, as
+// email HTML often is not well formed: a "<" that doesn't start a tag is
+// text, and an unterminated tag or comment runs to the end of the document
+func tokenizeHTML(source string) []htmlToken {
+	var tokens []htmlToken
+	i := 0
+	for i < len(source) {
+		if source[i] != '<' {
+			end := strings.IndexByte(source[i+1:], '<')
+			if end < 0 {
+				end = len(source)
+			} else {
+				end += i + 1
+			}
+			tokens = appendHTMLText(tokens, source[i:end])
+			i = end
+			continue
+		}
+
+		rest := source[i:]
+		switch {
+		case strings.HasPrefix(rest, "<!--"):
+			end := strings.Index(rest[4:], "-->")
+			if end < 0 {
+				end = len(rest)
+			} else {
+				end += 4 + 3
+			}
+			tokens = append(tokens, htmlToken{kind: htmlOther, raw: rest[:end]})
+			i += end
+
+		case strings.HasPrefix(rest, "<!") || strings.HasPrefix(rest, "<?"):
+			end := strings.IndexByte(rest, '>')
+			if end < 0 {
+				end = len(rest) - 1
+			}
+			tokens = append(tokens, htmlToken{kind: htmlOther, raw: rest[:end+1]})
+			i += end + 1
+
+		case len(rest) > 2 && rest[1] == '/' && isASCIILetter(rest[2]):
+			end := strings.IndexByte(rest, '>')
+			if end < 0 {
+				end = len(rest) - 1
+			}
+			name := rest[2:end]
+			if space := strings.IndexAny(name, " \t\r\n/"); space >= 0 {
+				name = name[:space]
+			}
+			tokens = append(tokens, htmlToken{
+				kind: htmlEndTag,
+				name: strings.ToLower(name),
+				raw:  rest[:end+1],
+			})
+			i += end + 1
+
+		case len(rest) > 1 && isASCIILetter(rest[1]):
+			token, length := parseHTMLStartTag(rest)
+			tokens = append(tokens, token)
+			i += length
+
+			// The content of raw text elements runs to their end tag
+			if htmlRawTextElements[token.name] && !token.selfClosing {
+				end := indexFold(source[i:], "</"+token.name)
+				if end < 0 {
+					end = len(source) - i
+				}
+				if end > 0 {
+					tokens = append(tokens, htmlToken{kind: htmlText, raw: source[i : i+end]})
+				}
+				i += end
+			}
+
+		default:
+			tokens = appendHTMLText(tokens, "<")
+			i++
+		}
+	}
+	return tokens
+}
+
+// appendHTMLText appends text, merging it with a preceding text token
+func appendHTMLText(tokens []htmlToken, text string) []htmlToken {
+	if last := len(tokens) - 1; last >= 0 && tokens[last].kind == htmlText {
+		tokens[last].raw += text
+		return tokens
+	}
+	return append(tokens, htmlToken{kind: htmlText, raw: text})
+}
+
+// parseHTMLStartTag parses the start tag at the beginning of source and
+// returns it along with its length
+func parseHTMLStartTag(source string) (htmlToken, int) {
+	token := htmlToken{kind: htmlStartTag}
+	i := 1
+	for i < len(source) && !isHTMLSpace(source[i]) && source[i] != '>' && source[i] != '/' {
+		i++
+	}
+	token.name = strings.ToLower(source[1:i])
+
+	for i < len(source) {
+		for i < len(source) && isHTMLSpace(source[i]) {
+			i++
+		}
+		if i >= len(source) {
+			break
+		}
+		if source[i] == '>' {
+			i++
+			break
+		}
+		if source[i] == '/' {
+			token.selfClosing = i+1 < len(source) && source[i+1] == '>'
+			i++
+			continue
+		}
+
+		start := i
+		for i < len(source) && !isHTMLSpace(source[i]) && source[i] != '=' && source[i] != '>' && source[i] != '/' {
+			i++
+		}
+		if i == start {
+			// A stray character such as a quote, skip it
+			i++
+			continue
+		}
+		attr := htmlAttr{name: strings.ToLower(source[start:i])}
+
+		for i < len(source) && isHTMLSpace(source[i]) {
+			i++
+		}
+		if i < len(source) && source[i] == '=' {
+			i++
+			for i < len(source) && isHTMLSpace(source[i]) {
+				i++
+			}
+			if i < len(source) && (source[i] == '"' || source[i] == '\'') {
+				quote := source[i]
+				end := strings.IndexByte(source[i+1:], quote)
+				if end < 0 {
+					end = len(source) - i - 1
+				}
+				attr.value = html.UnescapeString(source[i+1 : i+1+end])
+				i += end + 2
+			} else {
+				start = i
+				for i < len(source) && !isHTMLSpace(source[i]) && source[i] != '>' {
+					i++
+				}
+				attr.value = html.UnescapeString(source[start:i])
+			}
+		}
+		token.attrs = append(token.attrs, attr)
+	}
+
+	if i > len(source) {
+		i = len(source)
+	}
+	token.raw = source[:i]
+	return token, i
+}
+
+// indexFold returns the index of the first ASCII case insensitive match
+// of substr in s, or -1
+func indexFold(s string, substr string) int {
+	for i := 0; i+len(substr) <= len(s); i++ {
+		if strings.EqualFold(s[i:i+len(substr)], substr) {
+			return i
+		}
+	}
+	return -1
+}
+
+// isASCIILetter returns true for a-z and A-Z
+func isASCIILetter(c byte) bool {
+	return (c >= 'a' && c <= 'z') || (c >= 'A' && c <= 'Z')
+}
+
+// isHTMLSpace returns true for HTML whitespace
+func isHTMLSpace(c byte) bool {
+	return c == ' ' || c == '\t' || c == '\n' || c == '\r' || c == '\f'
+}
@@ -0,0 +1,95 @@
+package messagingapi
+
+import (
+	"sync"
+	"time"
+)
+
+// IdempotencyKeyHeader is the header the idempotency key is sent in
+const IdempotencyKeyHeader = "Idempotency-Key"
+
+// idempotencyCache remembers the results of requests sent with an
+// idempotency key, so a repeated key does not send the message again.
+// Requests with a key that is in flight wait for it to complete
+type idempotencyCache struct {
+	lock      sync.Mutex
+	ttl       time.Duration
+	entries   map[string]idempotencyEntry
+	inFlight  map[string]chan struct{}
+	lastSweep time.Time
+}
+
+// idempotencyEntry is a remembered result
+type idempotencyEntry struct {
+	result  NewMessageResult
+	expires time.Time
+}
+
+// newIdempotencyCache creates a cache keeping results for ttl
+func newIdempotencyCache(ttl time.Duration) *idempotencyCache {
+	if ttl <= 0 {
+		ttl = 24 * time.Hour
+	}
+	return &idempotencyCache{
+		ttl:       ttl,
+		entries:   make(map[string]idempotencyEntry),
+		inFlight:  make(map[string]chan struct{}),
+		lastSweep: time.Now(),
+	}
+}
+
+// claim returns the remembered result for the route and key. Without one
+// the key is claimed until release is called, after put when the request
+// succeeded. Other requests with the key wait for the release, and send
+// the request themselves when it failed
+func (c *idempotencyCache) claim(route string, key string) (NewMessageResult, bool, func()) {
+	cacheKey := route + "\n" + key
+
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	for {
+		entry, ok := c.entries[cacheKey]
+		if ok && !time.Now().After(entry.expires) {
+			return entry.result, true, nil
+		}
+		done, busy := c.inFlight[cacheKey]
+		if !busy {
+			break
+		}
+		c.lock.Unlock()
+		<-done
+		c.lock.Lock()
+	}
+
+	done := make(chan struct{})
+	c.inFlight[cacheKey] = done
+	return NewMessageResult{}, false, func() {
+		c.lock.Lock()
+		delete(c.inFlight, cacheKey)
+		c.lock.Unlock()
+		close(done)
+	}
+}
+
+// put remembers the result for the route and key
+func (c *idempotencyCache) put(route string, key string, result NewMessageResult) {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+
+	now := time.Now()
+	c.entries[route+"\n"+key] = idempotencyEntry{
+		result:  result,
+		expires: now.Add(c.ttl),
+	}
+
+	// Drop expired entries at most once per ttl
+	if now.Sub(c.lastSweep) < c.ttl {
+		return
+	}
+	for cacheKey, entry := range c.entries {
+		if now.After(entry.expires) {
+			delete(c.entries, cacheKey)
+		}
+	}
+	c.lastSweep = now
+}
@@ -0,0 +1,398 @@
+package messagingapi
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"mime"
+	"mime/multipart"
+	"mime/quotedprintable"
+	"net/mail"
+	"net/textproto"
+	"regexp"
+	"strings"
+	"time"
+	"unicode/utf8"
+)
+
+// ParsedEmail is an incoming email decoded from IncomingEmail.ContentBase64
+type ParsedEmail struct {
+	// Header holds all the headers of the email
+	Header  mail.Header
+	From    *mail.Address
+	To      []*mail.Address
+	Cc      []*mail.Address
+	ReplyTo []*mail.Address
+	Subject string
+	// Date is zero if the email has no valid Date header
+	Date time.Time
+	// MessageID is the Message-ID header, without angle brackets
+	MessageID string
+	// InReplyTo and References are the message IDs the email replies to,
+	// without angle brackets
+	InReplyTo  []string
+	References []string
+	// Text and HTML are the first plain text and HTML bodies, decoded to UTF-8
+	Text string
+	HTML string
+	// Attachments are the attached files and inline images
+	Attachments []IncomingAttachment
+	// NewContent is the text the sender wrote, with the quoted original
+	// message and signature removed
+	NewContent string
+	// OriginalMessageID is the MessageId of the email this is a reply to,
+	// as assigned by Create
+	OriginalMessageID string
+}
+
+// IncomingAttachment is a file attached to an incoming email
+type IncomingAttachment struct {
+	Filename    string
+	ContentType string
+	// ContentID identifies an inline attachment, without angle brackets
+	ContentID string
+	// Inline is true if the attachment is shown in the body
+	Inline bool
+	data   []byte
+}
+
+// Size returns the decoded size of the attachment in bytes
+func (attachment IncomingAttachment) Size() int {
+	return len(attachment.data)
+}
+
+// Reader returns a reader over the decoded content of the attachment
+func (attachment IncomingAttachment) Reader() io.Reader {
+	return bytes.NewReader(attachment.data)
+}
+
+// Parse decodes and parses the raw email. The body is read from the first
+// text/plain and text/html parts, every other part with a filename or an
+// attachment disposition is an attachment
+func (email IncomingEmail) Parse() (*ParsedEmail, error) {
+	raw, err := decodeEmailBase64(email.ContentBase64)
+	if err != nil {
+		return nil, fmt.Errorf("Unable to decode email content: %s", err.Error())
+	}
+
+	message, err := mail.ReadMessage(bytes.NewReader(raw))
+	if err != nil {
+		return nil, fmt.Errorf("Unable to parse email: %s", err.Error())
+	}
+
+	decoder := new(mime.WordDecoder)
+	parsed := &ParsedEmail{Header: message.Header}
+	parsed.Subject, err = decoder.DecodeHeader(message.Header.Get("Subject"))
+	if err != nil {
+		parsed.Subject = message.Header.Get("Subject")
+	}
+	if from, err := message.Header.AddressList("From"); err == nil && len(from) > 0 {
+		parsed.From = from[0]
+	}
+	parsed.To, _ = message.Header.AddressList("To")
+	parsed.Cc, _ = message.Header.AddressList("Cc")
+	parsed.ReplyTo, _ = message.Header.AddressList("Reply-To")
+	if date, err := message.Header.Date(); err == nil {
+		parsed.Date = date
+	}
+	if ids := parseMessageIDs(message.Header.Get("Message-ID")); len(ids) > 0 {
+		parsed.MessageID = ids[0]
+	}
+	parsed.InReplyTo = parseMessageIDs(message.Header.Get("In-Reply-To"))
+	parsed.References = parseMessageIDs(message.Header.Get("References"))
+
+	err = parsed.readPart(textproto.MIMEHeader(message.Header), message.Body, 0)
+	if err != nil {
+		return nil, err
+	}
+
+	content := parsed.Text
+	if strings.TrimSpace(content) == "" && parsed.HTML != "" {
+		content = HTMLToText(parsed.HTML)
+	}
+	parsed.NewContent = StripQuotedReply(content)
+
+	parsed.OriginalMessageID = email.MessageId
+	if parsed.OriginalMessageID == "" {
+		parsed.OriginalMessageID = parsed.repliedToID()
+	}
+	return parsed, nil
+}
+
+// IsReplyTo returns true if the email replies to the message, going by
+// OriginalMessageID and the In-Reply-To and References headers
+func (parsed *ParsedEmail) IsReplyTo(messageID string) bool {
+	if messageID == "" {
+		return false
+	}
+	if parsed.OriginalMessageID == messageID {
+		return true
+	}
+	for _, ids := range [][]string{parsed.InReplyTo, parsed.References} {
+		for _, id := range ids {
+			if id == messageID || strings.HasPrefix(id, messageID+"@") {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// repliedToID returns the local part of the message ID the email replies
+// to, which is the MessageId for emails sent via Create
+func (parsed *ParsedEmail) repliedToID() string {
+	id := ""
+	if len(parsed.InReplyTo) > 0 {
+		id = parsed.InReplyTo[0]
+	} else if len(parsed.References) > 0 {
+		id = parsed.References[len(parsed.References)-1]
+	}
+	if at := strings.LastIndexByte(id, '@'); at >= 0 {
+		id = id[:at]
+	}
+	return id
+}
+
+// maxEmailPartDepth limits the nesting of multipart bodies
+const maxEmailPartDepth = 10
+
+// readPart reads the body of a part, recursing into multipart bodies
+func (parsed *ParsedEmail) readPart(header textproto.MIMEHeader, body io.Reader, depth int) error {
+	if depth > maxEmailPartDepth {
+		return errors.New("Email parts are nested too deep")
+	}
+
+	mediaType, params, err := mime.ParseMediaType(header.Get("Content-Type"))
+	if err != nil {
+		mediaType = "text/plain"
+		params = map[string]string{}
+	}
+	body = decodeTransferEncoding(header.Get("Content-Transfer-Encoding"), body)
+
+	if strings.HasPrefix(mediaType, "multipart/") {
+		boundary := params["boundary"]
+		if boundary == "" {
+			return errors.New("Email multipart body has no boundary")
+		}
+		reader := multipart.NewReader(body, boundary)
+		for {
+			part, err := reader.NextRawPart()
+			if err == io.EOF {
+				return nil
+			}
+			if err != nil {
+				return fmt.Errorf("Unable to read email part: %s", err.Error())
+			}
+			err = parsed.readPart(part.Header, part, depth+1)
+			if err != nil {
+				return err
+			}
+		}
+	}
+
+	content, err := ioutil.ReadAll(body)
+	if err != nil {
+		return fmt.Errorf("Unable to read email part: %s", err.Error())
+	}
+
+	disposition, dispositionParams, _ := mime.ParseMediaType(header.Get("Content-Disposition"))
+	filename := dispositionParams["filename"]
+	if filename == "" {
+		filename = params["name"]
+	}
+	decoder := new(mime.WordDecoder)
+	if decoded, err := decoder.DecodeHeader(filename); err == nil {
+		filename = decoded
+	}
+
+	isBody := disposition != "attachment" && filename == ""
+	switch {
+	case isBody && mediaType == "text/plain" && parsed.Text == "":
+		parsed.Text = decodeCharset(content, params["charset"])
+	case isBody && mediaType == "text/html" && parsed.HTML == "":
+		parsed.HTML = decodeCharset(content, params["charset"])
+	case disposition == "attachment" || filename != "" || header.Get("Content-ID") != "":
+		contentID := ""
+		if ids := parseMessageIDs(header.Get("Content-ID")); len(ids) > 0 {
+			contentID = ids[0]
+		}
+		parsed.Attachments = append(parsed.Attachments, IncomingAttachment{
+			Filename:    filename,
+			ContentType: mediaType,
+			ContentID:   contentID,
+			Inline:      disposition == "inline" || (disposition == "" && contentID != ""),
+			data:        content,
+		})
+	}
+	return nil
+}
+
+// decodeTransferEncoding wraps the body in a decoder for its encoding
+func decodeTransferEncoding(encoding string, body io.Reader) io.Reader {
+	switch strings.ToLower(strings.TrimSpace(encoding)) {
+	case "base64":
+		return base64.NewDecoder(base64.StdEncoding, &base64Cleaner{reader: bufio.NewReader(body)})
+	case "quoted-printable":
+		return quotedprintable.NewReader(body)
+	}
+	return body
+}
+
+// base64Cleaner drops the characters from a base64 body which the decoder
+// doesn't accept, such as spaces and tabs, and stops at the end of the
+// padding so trailing garbage is ignored
+type base64Cleaner struct {
+	reader *bufio.Reader
+	// count is the amount of base64 characters read so far
+	count  int
+	padded bool
+	done   bool
+}
+
+// Read reads only base64 alphabet characters, up to the end of the padding
+func (c *base64Cleaner) Read(p []byte) (int, error) {
+	n := 0
+	for n < len(p) {
+		if c.done {
+			break
+		}
+		b, err := c.reader.ReadByte()
+		if err != nil {
+			if n > 0 && err == io.EOF {
+				return n, nil
+			}
+			return n, err
+		}
+		isPadding := b == '='
+		if !isPadding && !((b >= 'A' && b <= 'Z') || (b >= 'a' && b <= 'z') || (b >= '0' && b <= '9') || b == '+' || b == '/') {
+			continue
+		}
+		if c.padded && !isPadding {
+			c.done = true
+			break
+		}
+		c.padded = c.padded || isPadding
+		p[n] = b
+		n++
+		c.count++
+		if c.padded && c.count%4 == 0 {
+			c.done = true
+		}
+	}
+	if n == 0 && c.done {
+		return 0, io.EOF
+	}
+	return n, nil
+}
+
+// decodeEmailBase64 decodes the content, ignoring line breaks and
+// tolerating missing padding
+func decodeEmailBase64(content string) ([]byte, error) {
+	cleaned := strings.Map(func(r rune) rune {
+		if r == '\r' || r == '\n' || r == ' ' || r == '\t' {
+			return -1
+		}
+		return r
+	}, content)
+	if cleaned == "" {
+		return nil, errors.New("Email content is blank")
+	}
+	raw, err := base64.StdEncoding.DecodeString(cleaned)
+	if err != nil {
+		raw, err = base64.RawStdEncoding.DecodeString(strings.TrimRight(cleaned, "="))
+	}
+	return raw, err
+}
+
+// decodeCharset converts text to UTF-8. Only UTF-8, US-ASCII and
+// ISO-8859-1 and its Windows superset are converted, other charsets are
+// returned as is
+func decodeCharset(content []byte, charset string) string {
+	switch strings.ToLower(strings.TrimSpace(charset)) {
+	case "iso-8859-1", "latin1", "windows-1252", "cp1252":
+		if utf8.Valid(content) {
+			return string(content)
+		}
+		runes := make([]rune, len(content))
+		for i, b := range content {
+			runes[i] = rune(b)
+		}
+		return string(runes)
+	}
+	return string(content)
+}
+
+// parseMessageIDs returns the message IDs in a header such as References,
+// without angle brackets
+func parseMessageIDs(value string) []string {
+	var ids []string
+	for _, field := range strings.Fields(value) {
+		id := strings.Trim(field, "<>,")
+		if id != "" {
+			ids = append(ids, id)
+		}
+	}
+	return ids
+}
+
+var (
+	// quoteHeaderPattern matches the line email clients put above a quoted
+	// reply, such as "On Mon, 1 Jan 2024 at 10:00, Jane <jane@example.com> wrote:"
+	quoteHeaderPattern = regexp.MustCompile(`(?i)^(on\s.+wrote:|.+\s(wrote|schreef|skryf|a écrit)\s*:)$`)
+	// quoteSeparatorPattern matches the separators Outlook and others put
+	// above the original message
+	quoteSeparatorPattern = regexp.MustCompile(`(?i)^(-{2,}\s*(original message|forwarded message)\s*-{2,}|_{20,})$`)
+	// quoteFromPattern matches the first line of an Outlook style header
+	// block of the original message
+	quoteFromPattern = regexp.MustCompile(`(?i)^\*?from:\*?\s`)
+	// quoteHeaderFieldPattern matches the other lines of that block
+	quoteHeaderFieldPattern = regexp.MustCompile(`(?i)^\*?(sent|date|to|subject):\*?\s`)
+)
+
+// StripQuotedReply returns the text the sender of a reply wrote, removing
+// the quoted original message, the line introducing it and the signature
+func StripQuotedReply(text string) string {
+	lines := strings.Split(strings.Replace(text, "\r\n", "\n", -1), "\n")
+
+	end := len(lines)
+	for i := 0; i < len(lines) && i < end; i++ {
+		line := strings.TrimSpace(lines[i])
+
+		// The signature delimiter is "-- " but trailing spaces are often lost
+		if lines[i] == "-- " || line == "--" {
+			end = i
+			break
+		}
+		if strings.HasPrefix(line, ">") {
+			end = i
+			break
+		}
+		if quoteSeparatorPattern.MatchString(line) {
+			end = i
+			break
+		}
+		// The quote header may be wrapped over two lines
+		if quoteHeaderPattern.MatchString(line) {
+			end = i
+			break
+		}
+		if i+1 < len(lines) && strings.HasPrefix(strings.ToLower(line), "on ") &&
+			quoteHeaderPattern.MatchString(line+" "+strings.TrimSpace(lines[i+1])) {
+			end = i
+			break
+		}
+		if quoteFromPattern.MatchString(line) {
+			for j := i + 1; j < len(lines) && j <= i+4; j++ {
+				if quoteHeaderFieldPattern.MatchString(strings.TrimSpace(lines[j])) {
+					end = i
+					break
+				}
+			}
+		}
+	}
+	return strings.TrimSpace(strings.Join(lines[:end], "\n"))
+}
@@ -0,0 +1,163 @@
+package messagingapi
+
+import (
+	"bytes"
+	"encoding/base64"
+	"io/ioutil"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// testIncomingAttachment is the expected content of an IncomingAttachment
+type testIncomingAttachment struct {
+	filename    string
+	contentType string
+	contentID   string
+	inline      bool
+	size        int
+	prefix      string
+}
+
+func TestIncomingEmailParse(t *testing.T) {
+	tests := []struct {
+		file              string
+		messageID         string
+		fromName          string
+		fromAddress       string
+		subject           string
+		text              []string
+		html              []string
+		attachments       []testIncomingAttachment
+		newContent        string
+		originalMessageID string
+	}{
+		{
+			file:        "gmail_reply.eml",
+			fromName:    "Théo Nkosi",
+			fromAddress: "theo.nkosi@gmail.com",
+			subject:     "Re: Your March statement",
+			text:        []string{"I paid R1 234,50 on 28 February – proof", "> Dear Théo,"},
+			html:        []string{`<div class="gmail_quote">`, "Dear Théo,"},
+			attachments: []testIncomingAttachment{
+				{filename: "Proof of payment.pdf", contentType: "application/pdf", contentID: "f_m7t2b9x40", size: 83, prefix: "%PDF-1.4\n"},
+			},
+			newContent:        "Hi, the amount on my statement is wrong. I paid R1 234,50 on 28 February – proof of payment attached.\n\nThéo",
+			originalMessageID: "MSG123",
+		},
+		{
+			file:              "outlook_latin1.eml",
+			fromName:          "René van der Merwe",
+			fromAddress:       "rene@vdmerwe.co.za",
+			subject:           "RE: Your March statement",
+			text:              []string{"Goeie môre,", "na Café Straat 12 verander?", "From: Acme Billing"},
+			html:              []string{"<div>René</div>"},
+			newContent:        "Goeie môre,\n\nEk het die state ontvang, dankie. Kan julle asseblief my adres na Café Straat 12 verander?\n\nGroete\nRené",
+			originalMessageID: "MSG456",
+		},
+		{
+			file:              "outlook_latin1.eml",
+			messageID:         "MSG455",
+			fromName:          "René van der Merwe",
+			fromAddress:       "rene@vdmerwe.co.za",
+			subject:           "RE: Your March statement",
+			newContent:        "Goeie môre,\n\nEk het die state ontvang, dankie. Kan julle asseblief my adres na Café Straat 12 verander?\n\nGroete\nRené",
+			originalMessageID: "MSG455",
+		},
+		{
+			file:        "applemail_inline_image.eml",
+			fromName:    "Lerato Mokoena",
+			fromAddress: "lerato@icloud.com",
+			subject:     "Re: Call back",
+			html:        []string{`<img src="cid:7F3A2C1E-logo@acme">`, `<blockquote type="cite">`},
+			attachments: []testIncomingAttachment{
+				{contentType: "image/png", contentID: "7F3A2C1E-logo@acme", inline: true, size: 70, prefix: "\x89PNG"},
+			},
+			newContent:        "Thanks, that works for me.",
+			originalMessageID: "MSG789",
+		},
+		{
+			file:              "base64_footer.eml",
+			fromAddress:       "0821234567@vodamail.co.za",
+			subject:           "Re: Call back",
+			text:              []string{"Please call me back after 5pm."},
+			newContent:        "Please call me back after 5pm.\n\nSent from my Huawei phone",
+			originalMessageID: "MSG790",
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.file, func(t *testing.T) {
+			raw, err := ioutil.ReadFile(filepath.Join("testdata", "incomingemail", test.file))
+			if err != nil {
+				t.Fatal(err)
+			}
+			email := IncomingEmail{MessageId: test.messageID, ContentBase64: base64.StdEncoding.EncodeToString(raw)}
+			parsed, err := email.Parse()
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if parsed.From == nil || parsed.From.Name != test.fromName || parsed.From.Address != test.fromAddress {
+				t.Errorf("From is %v, want %q <%s>", parsed.From, test.fromName, test.fromAddress)
+			}
+			if parsed.Subject != test.subject {
+				t.Errorf("Subject is %q, want %q", parsed.Subject, test.subject)
+			}
+			for _, want := range test.text {
+				if !strings.Contains(parsed.Text, want) {
+					t.Errorf("Text %q does not contain %q", parsed.Text, want)
+				}
+			}
+			for _, want := range test.html {
+				if !strings.Contains(parsed.HTML, want) {
+					t.Errorf("HTML %q does not contain %q", parsed.HTML, want)
+				}
+			}
+			if len(parsed.Attachments) != len(test.attachments) {
+				t.Fatalf("Got %d attachments, want %d", len(parsed.Attachments), len(test.attachments))
+			}
+			for i, want := range test.attachments {
+				got := parsed.Attachments[i]
+				if got.Filename != want.filename || got.ContentType != want.contentType || got.ContentID != want.contentID || got.Inline != want.inline {
+					t.Errorf("Attachment %d is %q %q %q inline %v, want %q %q %q inline %v", i,
+						got.Filename, got.ContentType, got.ContentID, got.Inline, want.filename, want.contentType, want.contentID, want.inline)
+				}
+				data, _ := ioutil.ReadAll(got.Reader())
+				if got.Size() != want.size || !bytes.HasPrefix(data, []byte(want.prefix)) {
+					t.Errorf("Attachment %d is %d bytes starting with %q, want %d bytes starting with %q", i, got.Size(), data[:4], want.size, want.prefix)
+				}
+			}
+			if parsed.NewContent != test.newContent {
+				t.Errorf("NewContent is %q, want %q", parsed.NewContent, test.newContent)
+			}
+			if parsed.OriginalMessageID != test.originalMessageID {
+				t.Errorf("OriginalMessageID is %q, want %q", parsed.OriginalMessageID, test.originalMessageID)
+			}
+		})
+	}
+}
+
+func TestStripQuotedReply(t *testing.T) {
+	tests := []struct {
+		name string
+		text string
+		want string
+	}{
+		{"gmail", "Yes\n\nOn Mon, 3 Mar 2025 at 09:15, Acme <a@acme.co.za> wrote:\n> Hello", "Yes"},
+		{"gmail wrapped", "Yes\n\nOn Mon, 3 Mar 2025 at 09:15, Acme <a@acme.co.za>\nwrote:\n> Hello", "Yes"},
+		{"outlook separator", "Yes\n\n-----Original Message-----\nFrom: Acme\nSent: Monday", "Yes"},
+		{"outlook header block", "Yes\n\nFrom: Acme <a@acme.co.za>\nSent: Monday, March 3, 2025 9:15 AM\nTo: Théo", "Yes"},
+		{"afrikaans", "Ja\n\nAcme Billing skryf:\n> Hallo", "Ja"},
+		{"signature", "Yes\n-- \nThéo Nkosi\n082 123 4567", "Yes"},
+		{"from in the text is kept", "From: the start of March I moved.\nThanks", "From: the start of March I moved.\nThanks"},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			if got := StripQuotedReply(test.text); got != test.want {
+				t.Errorf("StripQuotedReply(%q) is %q, want %q", test.text, got, test.want)
+			}
+		})
+	}
+}
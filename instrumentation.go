@@ -0,0 +1,289 @@
+package messagingapi
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Error classes reported in RequestOutcome
+const (
+	ErrorClassNone        = "ok"
+	ErrorClassNetwork     = "network"
+	ErrorClassTimeout     = "timeout"
+	ErrorClassAuth        = "auth"
+	ErrorClassRateLimited = "rate_limited"
+	ErrorClassClient      = "client_error"
+	ErrorClassServer      = "server_error"
+	// The request was rejected by an open CircuitBreaker without a call
+	ErrorClassCircuitOpen = "circuit_open"
+)
+
+// RequestIDHeader is the header the request ID is sent in
+const RequestIDHeader = "X-Request-ID"
+
+// Instrumentation receives hooks around every HTTP call made to the API,
+// including retries. Hooks are called concurrently and must not block
+type Instrumentation interface {
+	// BeforeRequest is called before the HTTP call is made. The value it
+	// returns, such as a trace span, is passed to AfterRequest for the same
+	// call. When the value implements HeaderInjector its headers are added
+	// to the HTTP call
+	BeforeRequest(info RequestInfo) interface{}
+	// AfterRequest is called once the HTTP call completed, with the value
+	// returned by BeforeRequest
+	AfterRequest(info RequestInfo, call interface{}, outcome RequestOutcome)
+}
+
+// HeaderInjector adds headers to an HTTP call, such as the traceparent
+// header of a span. The headers are shared by the retries of a request,
+// so use Set rather than Add
+type HeaderInjector interface {
+	InjectHeader(header http.Header)
+}
+
+// Span is a trace span around a single HTTP call
+type Span interface {
+	// SetAttribute records an attribute on the span
+	SetAttribute(key string, value interface{})
+	// End ends the span, err is nil on success
+	End(err error)
+}
+
+// Tracer starts spans, adapt it to the tracing library in use. Spans that
+// implement HeaderInjector propagate the trace to the API
+type Tracer interface {
+	StartSpan(name string) Span
+}
+
+// TracingInstrumentation starts a span around every HTTP call, named
+// "messagingapi <route>", with the RequestInfo and RequestOutcome
+// recorded as attributes
+type TracingInstrumentation struct {
+	tracer Tracer
+}
+
+// NewTracingInstrumentation creates an Instrumentation reporting spans to
+// the tracer
+func NewTracingInstrumentation(tracer Tracer) *TracingInstrumentation {
+	return &TracingInstrumentation{tracer: tracer}
+}
+
+// BeforeRequest starts the span of the call
+func (t *TracingInstrumentation) BeforeRequest(info RequestInfo) interface{} {
+	span := t.tracer.StartSpan("messagingapi " + info.Route)
+	span.SetAttribute("messagingapi.route", info.Route)
+	span.SetAttribute("http.method", info.Method)
+	span.SetAttribute("messagingapi.request_id", info.RequestID)
+	span.SetAttribute("messagingapi.attempt", info.Attempt)
+	if info.Endpoint != "" {
+		span.SetAttribute("messagingapi.endpoint", info.Endpoint)
+	}
+	if info.MVNOID != 0 {
+		span.SetAttribute("messagingapi.mvno_id", info.MVNOID)
+	}
+	if info.Campaign != "" {
+		span.SetAttribute("messagingapi.campaign", info.Campaign)
+	}
+	if info.ActionType != 0 {
+		span.SetAttribute("messagingapi.action_type", info.ActionType)
+	}
+	if info.MessageID != "" {
+		span.SetAttribute("messagingapi.message_id", info.MessageID)
+	}
+	return span
+}
+
+// AfterRequest records the outcome and ends the span of the call
+func (t *TracingInstrumentation) AfterRequest(info RequestInfo, call interface{}, outcome RequestOutcome) {
+	span, ok := call.(Span)
+	if !ok {
+		return
+	}
+	if outcome.StatusCode != 0 {
+		span.SetAttribute("http.status_code", outcome.StatusCode)
+	}
+	span.SetAttribute("messagingapi.outcome", outcome.ErrorClass)
+	if info.MessageID != "" {
+		span.SetAttribute("messagingapi.message_id", info.MessageID)
+	}
+	span.End(outcome.Err)
+}
+
+// RequestOutcome describes the result of an HTTP call to the API
+type RequestOutcome struct {
+	// StatusCode is the HTTP status code, zero if there was no response
+	StatusCode int
+	// ErrorClass is one of ErrorClass*
+	ErrorClass string
+	// Err is the error returned by the call, nil on success
+	Err error
+	// Duration is how long the call took
+	Duration time.Duration
+}
+
+// ClassifyError returns the ErrorClass* of a completed call
+func ClassifyError(statusCode int, err error) string {
+	if err == nil {
+		return ErrorClassNone
+	}
+	if errors.Is(err, ErrCircuitOpen) {
+		return ErrorClassCircuitOpen
+	}
+
+	switch {
+	case statusCode == http.StatusUnauthorized || statusCode == http.StatusForbidden:
+		return ErrorClassAuth
+	case statusCode == http.StatusTooManyRequests:
+		return ErrorClassRateLimited
+	case statusCode >= http.StatusInternalServerError:
+		return ErrorClassServer
+	case statusCode >= http.StatusBadRequest:
+		return ErrorClassClient
+	}
+
+	var netErr net.Error
+	if errors.As(err, &netErr) && netErr.Timeout() {
+		return ErrorClassTimeout
+	}
+	return ErrorClassNetwork
+}
+
+// newRequestID returns a random ID to correlate the attempts of a request
+func newRequestID() string {
+	id := make([]byte, 8)
+	if _, err := rand.Read(id); err != nil {
+		return fmt.Sprintf("%x", time.Now().UnixNano())
+	}
+	return hex.EncodeToString(id)
+}
+
+// Default histogram buckets of PrometheusInstrumentation, in seconds
+var DefaultLatencyBuckets = []float64{0.05, 0.1, 0.25, 0.5, 1, 2.5, 5, 10, 30}
+
+// PrometheusInstrumentation counts requests and their latency per route
+// and serves them in the Prometheus text format as an http.Handler
+type PrometheusInstrumentation struct {
+	lock     sync.Mutex
+	buckets  []float64
+	requests map[[2]string]uint64
+	inFlight map[string]int64
+	latency  map[string]*latencyHistogram
+}
+
+// latencyHistogram holds the cumulative bucket counts of a route
+type latencyHistogram struct {
+	counts []uint64
+	count  uint64
+	sum    float64
+}
+
+// NewPrometheusInstrumentation creates an Instrumentation exposing metrics,
+// buckets defaults to DefaultLatencyBuckets when empty
+func NewPrometheusInstrumentation(buckets ...float64) *PrometheusInstrumentation {
+	if len(buckets) == 0 {
+		buckets = DefaultLatencyBuckets
+	}
+	sorted := append([]float64(nil), buckets...)
+	sort.Float64s(sorted)
+
+	return &PrometheusInstrumentation{
+		buckets:  sorted,
+		requests: make(map[[2]string]uint64),
+		inFlight: make(map[string]int64),
+		latency:  make(map[string]*latencyHistogram),
+	}
+}
+
+// BeforeRequest counts the request as in flight
+func (p *PrometheusInstrumentation) BeforeRequest(info RequestInfo) interface{} {
+	p.lock.Lock()
+	defer p.lock.Unlock()
+	p.inFlight[info.Route]++
+	return nil
+}
+
+// AfterRequest records the outcome and latency of the request
+func (p *PrometheusInstrumentation) AfterRequest(info RequestInfo, call interface{}, outcome RequestOutcome) {
+	p.lock.Lock()
+	defer p.lock.Unlock()
+
+	p.inFlight[info.Route]--
+	p.requests[[2]string{info.Route, outcome.ErrorClass}]++
+
+	histogram, ok := p.latency[info.Route]
+	if !ok {
+		histogram = &latencyHistogram{counts: make([]uint64, len(p.buckets))}
+		p.latency[info.Route] = histogram
+	}
+	seconds := outcome.Duration.Seconds()
+	for i, bound := range p.buckets {
+		if seconds <= bound {
+			histogram.counts[i]++
+		}
+	}
+	histogram.count++
+	histogram.sum += seconds
+}
+
+// ServeHTTP writes the metrics in the Prometheus text exposition format
+func (p *PrometheusInstrumentation) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	p.lock.Lock()
+	defer p.lock.Unlock()
+
+	var out strings.Builder
+
+	out.WriteString("# HELP messagingapi_requests_total Messaging API HTTP calls by route and outcome.\n")
+	out.WriteString("# TYPE messagingapi_requests_total counter\n")
+	keys := make([][2]string, 0, len(p.requests))
+	for key := range p.requests {
+		keys = append(keys, key)
+	}
+	sort.Slice(keys, func(i, j int) bool {
+		if keys[i][0] != keys[j][0] {
+			return keys[i][0] < keys[j][0]
+		}
+		return keys[i][1] < keys[j][1]
+	})
+	for _, key := range keys {
+		fmt.Fprintf(&out, "messagingapi_requests_total{route=%q,outcome=%q} %d\n", key[0], key[1], p.requests[key])
+	}
+
+	out.WriteString("# HELP messagingapi_requests_in_flight Messaging API HTTP calls in progress by route.\n")
+	out.WriteString("# TYPE messagingapi_requests_in_flight gauge\n")
+	var routes []string
+	for route := range p.inFlight {
+		routes = append(routes, route)
+	}
+	sort.Strings(routes)
+	for _, route := range routes {
+		fmt.Fprintf(&out, "messagingapi_requests_in_flight{route=%q} %d\n", route, p.inFlight[route])
+	}
+
+	out.WriteString("# HELP messagingapi_request_duration_seconds Messaging API HTTP call latency by route.\n")
+	out.WriteString("# TYPE messagingapi_request_duration_seconds histogram\n")
+	routes = routes[:0]
+	for route := range p.latency {
+		routes = append(routes, route)
+	}
+	sort.Strings(routes)
+	for _, route := range routes {
+		histogram := p.latency[route]
+		for i, bound := range p.buckets {
+			fmt.Fprintf(&out, "messagingapi_request_duration_seconds_bucket{route=%q,le=\"%g\"} %d\n", route, bound, histogram.counts[i])
+		}
+		fmt.Fprintf(&out, "messagingapi_request_duration_seconds_bucket{route=%q,le=\"+Inf\"} %d\n", route, histogram.count)
+		fmt.Fprintf(&out, "messagingapi_request_duration_seconds_sum{route=%q} %g\n", route, histogram.sum)
+		fmt.Fprintf(&out, "messagingapi_request_duration_seconds_count{route=%q} %d\n", route, histogram.count)
+	}
+
+	w.Header().Set("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+	w.Write([]byte(out.String()))
+}
@@ -0,0 +1,516 @@
+package messagingapi
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+	"sync"
+	texttemplate "text/template"
+	"time"
+)
+
+// Built in locales
+const (
+	LocaleEnglish   = "en"
+	LocaleAfrikaans = "af"
+	LocaleZulu      = "zu"
+	LocaleSesotho   = "st"
+)
+
+// Plural categories returned by Locale.Plural
+const (
+	PluralZero  = "zero"
+	PluralOne   = "one"
+	PluralOther = "other"
+)
+
+// Locale holds the formatting rules of a language
+type Locale struct {
+	// Tag identifies the locale, such as "af" or "af-ZA"
+	Tag string
+	// Fallback is the locale used for messages missing from this one
+	// (optional)
+	Fallback string
+	// DecimalSeparator and GroupSeparator are used to format numbers
+	DecimalSeparator string
+	GroupSeparator   string
+	// CurrencyFormat is the format of amounts, %s is replaced with the
+	// formatted number. For example "R%s"
+	CurrencyFormat string
+	// Months and Weekdays replace the English names in formatted dates,
+	// starting from January and Sunday
+	Months   [12]string
+	Weekdays [7]string
+	// DateLayout is the layout used when formatting dates without one
+	DateLayout string
+	// Plural returns the plural category of a count, one of Plural*.
+	// Defaults to PluralOne for 1 and PluralOther otherwise
+	Plural func(count int) string
+}
+
+// englishPlural is the plural rule of English, Afrikaans and Sesotho
+func englishPlural(count int) string {
+	if count == 1 {
+		return PluralOne
+	}
+	return PluralOther
+}
+
+// zuluPlural is the plural rule of isiZulu, where zero is singular
+func zuluPlural(count int) string {
+	if count == 0 || count == 1 {
+		return PluralOne
+	}
+	return PluralOther
+}
+
+// builtInLocales are registered with every new bundle
+var builtInLocales = []Locale{
+	{
+		Tag:              LocaleEnglish,
+		DecimalSeparator: ".",
+		GroupSeparator:   ",",
+		CurrencyFormat:   "R%s",
+		Months:           [12]string{"January", "February", "March", "April", "May", "June", "July", "August", "September", "October", "November", "December"},
+		Weekdays:         [7]string{"Sunday", "Monday", "Tuesday", "Wednesday", "Thursday", "Friday", "Saturday"},
+		DateLayout:       "2 January 2006",
+		Plural:           englishPlural,
+	},
+	{
+		Tag:              LocaleAfrikaans,
+		Fallback:         LocaleEnglish,
+		DecimalSeparator: ",",
+		GroupSeparator:   " ",
+		CurrencyFormat:   "R%s",
+		Months:           [12]string{"Januarie", "Februarie", "Maart", "April", "Mei", "Junie", "Julie", "Augustus", "September", "Oktober", "November", "Desember"},
+		Weekdays:         [7]string{"Sondag", "Maandag", "Dinsdag", "Woensdag", "Donderdag", "Vrydag", "Saterdag"},
+		DateLayout:       "2 January 2006",
+		Plural:           englishPlural,
+	},
+	{
+		Tag:              LocaleZulu,
+		Fallback:         LocaleEnglish,
+		DecimalSeparator: ".",
+		GroupSeparator:   ",",
+		CurrencyFormat:   "R%s",
+		Months:           [12]string{"Januwari", "Februwari", "Mashi", "Ephreli", "Meyi", "Juni", "Julayi", "Agasti", "Septhemba", "Okthoba", "Novemba", "Disemba"},
+		Weekdays:         [7]string{"ISonto", "UMsombuluko", "ULwesibili", "ULwesithathu", "ULwesine", "ULwesihlanu", "UMgqibelo"},
+		DateLayout:       "2 January 2006",
+		Plural:           zuluPlural,
+	},
+	{
+		Tag:              LocaleSesotho,
+		Fallback:         LocaleEnglish,
+		DecimalSeparator: ".",
+		GroupSeparator:   " ",
+		CurrencyFormat:   "R%s",
+		Months:           [12]string{"Phesekgong", "Hlakola", "Hlakubele", "Mmese", "Motsheanong", "Phupjane", "Phupu", "Phata", "Lepotrihali", "Mphalane", "Pudungwana", "Tshitwe"},
+		Weekdays:         [7]string{"Sontaha", "Mmantaha", "Labobedi", "Laboraru", "Labone", "Labohlane", "Moqebelo"},
+		DateLayout:       "2 January 2006",
+		Plural:           englishPlural,
+	},
+}
+
+// Bundle holds localized message templates keyed by message ID and
+// locale. It is safe for concurrent use
+type Bundle struct {
+	lock          sync.RWMutex
+	defaultLocale string
+	locales       map[string]Locale
+	sources       map[string]map[string]string
+	messages      map[string]map[string]*texttemplate.Template
+}
+
+// NewBundle creates a bundle with the built in en, af, zu and st locales.
+// Messages missing from a locale and its fallbacks are taken from
+// defaultLocale
+func NewBundle(defaultLocale string) *Bundle {
+	b := &Bundle{
+		defaultLocale: normalizeLocale(defaultLocale),
+		locales:       make(map[string]Locale),
+		sources:       make(map[string]map[string]string),
+		messages:      make(map[string]map[string]*texttemplate.Template),
+	}
+	if b.defaultLocale == "" {
+		b.defaultLocale = LocaleEnglish
+	}
+	for _, locale := range builtInLocales {
+		b.locales[locale.Tag] = locale
+	}
+	return b
+}
+
+// AddLocale registers a locale, replacing one with the same tag
+func (b *Bundle) AddLocale(locale Locale) error {
+	locale.Tag = normalizeLocale(locale.Tag)
+	if locale.Tag == "" {
+		return errors.New("Locale tag can not be blank")
+	}
+	locale.Fallback = normalizeLocale(locale.Fallback)
+	if locale.Plural == nil {
+		locale.Plural = englishPlural
+	}
+
+	b.lock.Lock()
+	defer b.lock.Unlock()
+	b.locales[locale.Tag] = locale
+	return nil
+}
+
+// AddMessages adds the message templates of a locale, keyed by message ID.
+// Plural forms are added as separate IDs suffixed with the category, such
+// as "items.one" and "items.other". Templates use text/template, fail on
+// missing keys and can use the functions of Localizer.Funcs, which are
+// bound to the rendering localizer so fallback messages are formatted for
+// its locale
+func (b *Bundle) AddMessages(locale string, messages map[string]string) error {
+	locale = normalizeLocale(locale)
+	if locale == "" {
+		return errors.New("Locale tag can not be blank")
+	}
+
+	funcs := (&Localizer{}).Funcs()
+	parsed := make(map[string]*texttemplate.Template, len(messages))
+	for id, source := range messages {
+		t, err := texttemplate.New(id).Option("missingkey=error").Funcs(funcs).Parse(source)
+		if err != nil {
+			return fmt.Errorf("Unable to parse message %s for locale %s: %s", id, locale, err.Error())
+		}
+		parsed[id] = t
+	}
+
+	b.lock.Lock()
+	defer b.lock.Unlock()
+	if b.sources[locale] == nil {
+		b.sources[locale] = make(map[string]string)
+		b.messages[locale] = make(map[string]*texttemplate.Template)
+	}
+	for id, t := range parsed {
+		b.sources[locale][id] = messages[id]
+		b.messages[locale][id] = t
+	}
+	return nil
+}
+
+// Localizer returns a localizer for the locale, such as "zu" or "zu-ZA".
+// Messages are looked up in the locale, its language, their fallbacks and
+// finally the default locale
+func (b *Bundle) Localizer(locale string) *Localizer {
+	b.lock.RLock()
+	defer b.lock.RUnlock()
+
+	// A locale is followed by its language, then by its fallback
+	var chain []string
+	seen := make(map[string]bool)
+	var add func(tag string)
+	add = func(tag string) {
+		if tag == "" || seen[tag] {
+			return
+		}
+		seen[tag] = true
+		chain = append(chain, tag)
+		if dash := strings.IndexByte(tag, '-'); dash > 0 {
+			add(tag[:dash])
+		}
+		add(b.locales[tag].Fallback)
+	}
+	add(normalizeLocale(locale))
+	add(b.defaultLocale)
+
+	l := &Localizer{bundle: b, chain: chain, locale: b.locales[LocaleEnglish]}
+	for _, tag := range chain {
+		if found, ok := b.locales[tag]; ok {
+			l.locale = found
+			break
+		}
+	}
+	return l
+}
+
+// Localizer renders messages and formats values for one locale
+type Localizer struct {
+	bundle *Bundle
+	chain  []string
+	locale Locale
+}
+
+// Locale returns the formatting rules used by the localizer
+func (l *Localizer) Locale() Locale {
+	return l.locale
+}
+
+// Message renders the message with data
+func (l *Localizer) Message(id string, data interface{}) (string, error) {
+	t, ok := l.lookup(id)
+	if !ok {
+		return "", fmt.Errorf("Message %s not found for locale %s", id, l.chain[0])
+	}
+	t, err := t.Clone()
+	if err != nil {
+		return "", err
+	}
+	var out bytes.Buffer
+	err = t.Funcs(l.Funcs()).Execute(&out, data)
+	return out.String(), err
+}
+
+// Plural renders the plural form of the message for count. The form is
+// looked up as id.zero for a zero count, then id.<category>, then
+// id.other and finally id itself
+func (l *Localizer) Plural(id string, count int, data interface{}) (string, error) {
+	plural := l.locale.Plural
+	if plural == nil {
+		plural = englishPlural
+	}
+	var candidates []string
+	if count == 0 {
+		candidates = append(candidates, id+"."+PluralZero)
+	}
+	candidates = append(candidates, id+"."+plural(count), id+"."+PluralOther, id)
+	for _, candidate := range candidates {
+		if _, ok := l.lookup(candidate); ok {
+			return l.Message(candidate, data)
+		}
+	}
+	return "", fmt.Errorf("Message %s not found for locale %s", id, l.chain[0])
+}
+
+// FormatNumber formats the value with the locale's separators, rounded to
+// the amount of decimals
+func (l *Localizer) FormatNumber(value float64, decimals int) string {
+	if decimals < 0 {
+		decimals = 0
+	}
+	formatted := strconv.FormatFloat(math.Abs(value), 'f', decimals, 64)
+	whole, fraction := formatted, ""
+	if dot := strings.IndexByte(formatted, '.'); dot >= 0 {
+		whole, fraction = formatted[:dot], formatted[dot+1:]
+	}
+
+	var out strings.Builder
+	if value < 0 && strings.Trim(formatted, "0.") != "" {
+		out.WriteString("-")
+	}
+	for i, digit := range whole {
+		if i > 0 && (len(whole)-i)%3 == 0 {
+			out.WriteString(l.locale.GroupSeparator)
+		}
+		out.WriteRune(digit)
+	}
+	if fraction != "" {
+		out.WriteString(l.locale.DecimalSeparator + fraction)
+	}
+	return out.String()
+}
+
+// FormatCurrency formats an amount with two decimals in the locale's
+// currency format
+func (l *Localizer) FormatCurrency(amount float64) string {
+	format := l.locale.CurrencyFormat
+	if format == "" {
+		format = "%s"
+	}
+	number := l.FormatNumber(amount, 2)
+	sign := ""
+	if strings.HasPrefix(number, "-") {
+		sign, number = "-", number[1:]
+	}
+	return sign + strings.Replace(format, "%s", number, 1)
+}
+
+// FormatDate formats the time with a time.Format layout, replacing the
+// English month and weekday names with the locale's. Only full names are
+// replaced. A blank layout uses the locale's DateLayout
+func (l *Localizer) FormatDate(t time.Time, layout string) string {
+	if layout == "" {
+		layout = l.locale.DateLayout
+	}
+	if layout == "" {
+		layout = "2006-01-02"
+	}
+
+	// Format the names separately so text in the layout is left alone
+	const monthMarker, weekdayMarker = "\x00M\x00", "\x00W\x00"
+	layout = strings.Replace(layout, "January", monthMarker, -1)
+	layout = strings.Replace(layout, "Monday", weekdayMarker, -1)
+	formatted := t.Format(layout)
+
+	month := l.locale.Months[t.Month()-1]
+	if month == "" {
+		month = t.Month().String()
+	}
+	weekday := l.locale.Weekdays[t.Weekday()]
+	if weekday == "" {
+		weekday = t.Weekday().String()
+	}
+	formatted = strings.Replace(formatted, monthMarker, month, -1)
+	return strings.Replace(formatted, weekdayMarker, weekday, -1)
+}
+
+// Funcs returns the template functions of the localizer:
+//
+//	t        {{t "id" .}} renders a message
+//	plural   {{plural "id" .Count .}} renders the plural form for a count
+//	number   {{number .Value 2}} formats a number with decimals
+//	currency {{currency .Amount}} formats an amount
+//	date     {{date .When "2 January 2006"}} formats a time, "" for the locale's layout
+//
+// Use them in TemplateConfig.Funcs to localize SMS and email templates
+func (l *Localizer) Funcs() map[string]interface{} {
+	return map[string]interface{}{
+		"t": func(id string, data ...interface{}) (string, error) {
+			return l.Message(id, firstOrNil(data))
+		},
+		"plural": func(id string, count interface{}, data ...interface{}) (string, error) {
+			number, err := toFloat(count)
+			if err != nil {
+				return "", err
+			}
+			return l.Plural(id, int(number), firstOrNil(data))
+		},
+		"number": func(value interface{}, decimals int) (string, error) {
+			number, err := toFloat(value)
+			if err != nil {
+				return "", err
+			}
+			return l.FormatNumber(number, decimals), nil
+		},
+		"currency": func(value interface{}) (string, error) {
+			number, err := toFloat(value)
+			if err != nil {
+				return "", err
+			}
+			return l.FormatCurrency(number), nil
+		},
+		"date": l.FormatDate,
+	}
+}
+
+// SMSTemplate returns a template of the message, with the localizer's
+// functions available
+func (l *Localizer) SMSTemplate(id string) (*SMSTemplate, error) {
+	source, ok := l.source(id)
+	if !ok {
+		return nil, fmt.Errorf("Message %s not found for locale %s", id, l.chain[0])
+	}
+	return NewSMSTemplate(source, TemplateConfig{Funcs: l.Funcs()})
+}
+
+// EmailTemplate returns a template of the email messages, with the
+// localizer's functions available. htmlID or textID may be blank
+func (l *Localizer) EmailTemplate(subjectID string, htmlID string, textID string) (*EmailTemplate, error) {
+	var source EmailTemplateSource
+	for _, field := range []struct {
+		id     string
+		source *string
+	}{
+		{subjectID, &source.Subject},
+		{htmlID, &source.HTML},
+		{textID, &source.Text},
+	} {
+		if field.id == "" {
+			continue
+		}
+		found, ok := l.source(field.id)
+		if !ok {
+			return nil, fmt.Errorf("Message %s not found for locale %s", field.id, l.chain[0])
+		}
+		*field.source = found
+	}
+	return NewEmailTemplate(source, TemplateConfig{Funcs: l.Funcs()})
+}
+
+// BuildData returns a copy of data with every message rendered into it
+// under its ID, for use as BuildRequest.Data. The locale tag is added
+// under "locale"
+func (l *Localizer) BuildData(data map[string]interface{}, ids ...string) (map[string]interface{}, error) {
+	out := make(map[string]interface{}, len(data)+len(ids)+1)
+	for key, value := range data {
+		out[key] = value
+	}
+	for _, id := range ids {
+		message, err := l.Message(id, data)
+		if err != nil {
+			return nil, err
+		}
+		out[id] = message
+	}
+	out["locale"] = l.locale.Tag
+	return out, nil
+}
+
+// lookup returns the template of the message from the first locale in
+// the chain that has it
+func (l *Localizer) lookup(id string) (*texttemplate.Template, bool) {
+	l.bundle.lock.RLock()
+	defer l.bundle.lock.RUnlock()
+
+	for _, tag := range l.chain {
+		if t, ok := l.bundle.messages[tag][id]; ok {
+			return t, true
+		}
+	}
+	return nil, false
+}
+
+// source returns the template source of the message
+func (l *Localizer) source(id string) (string, bool) {
+	l.bundle.lock.RLock()
+	defer l.bundle.lock.RUnlock()
+
+	for _, tag := range l.chain {
+		if source, ok := l.bundle.sources[tag][id]; ok {
+			return source, true
+		}
+	}
+	return "", false
+}
+
+// normalizeLocale lowercases the locale and uses - as separator
+func normalizeLocale(locale string) string {
+	return strings.ToLower(strings.Replace(strings.TrimSpace(locale), "_", "-", -1))
+}
+
+// firstOrNil returns the first value, nil if there is none
+func firstOrNil(values []interface{}) interface{} {
+	if len(values) == 0 {
+		return nil
+	}
+	return values[0]
+}
+
+// toFloat converts a template value to a float
+func toFloat(value interface{}) (float64, error) {
+	switch v := value.(type) {
+	case int:
+		return float64(v), nil
+	case int8:
+		return float64(v), nil
+	case int16:
+		return float64(v), nil
+	case int32:
+		return float64(v), nil
+	case int64:
+		return float64(v), nil
+	case uint:
+		return float64(v), nil
+	case uint8:
+		return float64(v), nil
+	case uint16:
+		return float64(v), nil
+	case uint32:
+		return float64(v), nil
+	case uint64:
+		return float64(v), nil
+	case float32:
+		return float64(v), nil
+	case float64:
+		return v, nil
+	case string:
+		return strconv.ParseFloat(strings.TrimSpace(v), 64)
+	}
+	return 0, fmt.Errorf("Expected a number, got %T", value)
+}
@@ -0,0 +1,205 @@
+package messagingapi
+
+import (
+	"context"
+	"encoding/json"
+	"log/slog"
+	"regexp"
+	"strings"
+	"time"
+)
+
+// LogConfig configures request logging with log/slog
+type LogConfig struct {
+	// Logger receives the log records, defaults to slog.Default()
+	Logger *slog.Logger
+	// Level successful requests are logged at, defaults to slog.LevelInfo
+	Level slog.Leveler
+	// ErrorLevel failed requests are logged at, defaults to slog.LevelWarn
+	ErrorLevel slog.Leveler
+	// LogBodies adds the redacted request and response bodies to the log
+	LogBodies bool
+	// Redaction decides what is removed from logged bodies and errors,
+	// defaults to DefaultRedactionRules()
+	Redaction *RedactionRules
+}
+
+// RedactionRules decides which values are removed from logged bodies
+type RedactionRules struct {
+	// Fields are JSON keys, matched case insensitively, whose string values
+	// are replaced. Objects under a matching key are checked field by field
+	Fields []string
+	// Patterns are replaced in all other string values and error messages
+	Patterns []*regexp.Regexp
+	// Replacement is what redacted values are replaced with, defaults
+	// to "[REDACTED]"
+	Replacement string
+}
+
+// DefaultRedactionRules redacts MSISDNs, email addresses, message bodies,
+// base64 attachment and slide data, template data and report lines
+func DefaultRedactionRules() *RedactionRules {
+	return &RedactionRules{
+		Fields: []string{
+			"msisdn", "sourcemsisdn", "destinationmsisdn",
+			"email", "address", "replyto", "cc", "bcc",
+			"message", "text", "html", "subject",
+			"data", "content", "afterbuilddata", "lines",
+		},
+		Patterns: []*regexp.Regexp{
+			regexp.MustCompile(`[A-Za-z0-9._%+\-]+@[A-Za-z0-9.\-]+\.[A-Za-z]{2,}`),
+			regexp.MustCompile(`\+?\b[0-9]{9,15}\b`),
+		},
+		Replacement: "[REDACTED]",
+	}
+}
+
+// Redact returns the body with redacted values replaced. A body that is
+// not JSON is treated as a single string value
+func (rules *RedactionRules) Redact(body string) string {
+	if body == "" {
+		return body
+	}
+
+	var value interface{}
+	if err := json.Unmarshal([]byte(body), &value); err != nil {
+		return rules.redactString(body)
+	}
+
+	jsonBytes, err := json.Marshal(rules.redactValue(value, false))
+	if err != nil {
+		return rules.replacement()
+	}
+	return string(jsonBytes)
+}
+
+// redactValue walks a decoded JSON value, replacing the strings of
+// redacted fields and applying the patterns to the others
+func (rules *RedactionRules) redactValue(value interface{}, redact bool) interface{} {
+	switch v := value.(type) {
+	case map[string]interface{}:
+		for key, child := range v {
+			v[key] = rules.redactValue(child, rules.isField(key))
+		}
+		return v
+	case []interface{}:
+		for i, child := range v {
+			v[i] = rules.redactValue(child, redact)
+		}
+		return v
+	case string:
+		if redact {
+			return rules.replacement()
+		}
+		return rules.redactString(v)
+	}
+	return value
+}
+
+// redactString applies the patterns to a string
+func (rules *RedactionRules) redactString(value string) string {
+	for _, pattern := range rules.Patterns {
+		value = pattern.ReplaceAllString(value, rules.replacement())
+	}
+	return value
+}
+
+// isField returns true if the JSON key is redacted
+func (rules *RedactionRules) isField(key string) bool {
+	for _, field := range rules.Fields {
+		if strings.EqualFold(field, key) {
+			return true
+		}
+	}
+	return false
+}
+
+// replacement returns the configured replacement
+func (rules *RedactionRules) replacement() string {
+	if rules.Replacement == "" {
+		return "[REDACTED]"
+	}
+	return rules.Replacement
+}
+
+// logRequest logs a completed request. The access token is never logged,
+// and is removed from bodies and errors should the API echo it back
+func (config *LogConfig) logRequest(r *APIWebRequest, responseBody string, statusCode int, err error, latency time.Duration) {
+	logger := config.Logger
+	if logger == nil {
+		logger = slog.Default()
+	}
+
+	level := slog.LevelInfo
+	if config.Level != nil {
+		level = config.Level.Level()
+	}
+	if err != nil {
+		level = slog.LevelWarn
+		if config.ErrorLevel != nil {
+			level = config.ErrorLevel.Level()
+		}
+	}
+
+	ctx := context.Background()
+	if !logger.Enabled(ctx, level) {
+		return
+	}
+
+	rules := config.Redaction
+	if rules == nil {
+		rules = DefaultRedactionRules()
+	}
+	token, _ := r.token()
+	clean := func(value string) string {
+		if token != "" {
+			value = strings.ReplaceAll(value, token, rules.replacement())
+		}
+		return value
+	}
+
+	info := r.Info
+	isSubmit := info.Route == RouteSend || info.Route == RouteResend || info.Route == RouteGenerate
+	if isSubmit && responseBody != "" {
+		var messageResult NewMessageResult
+		if json.Unmarshal([]byte(responseBody), &messageResult) == nil {
+			info.MessageID = messageResult.MessageID
+		}
+	}
+
+	attrs := []slog.Attr{
+		slog.String("method", info.Method),
+		slog.String("route", info.Route),
+		slog.Int("status_code", statusCode),
+		slog.Duration("latency", latency),
+		slog.Int("attempts", info.Attempt),
+		slog.String("request_id", info.RequestID),
+	}
+	if info.MVNOID != 0 {
+		attrs = append(attrs, slog.Int("mvno", info.MVNOID))
+	}
+	if info.Campaign != "" {
+		attrs = append(attrs, slog.String("campaign", info.Campaign))
+	}
+	if info.ActionType != 0 {
+		attrs = append(attrs, slog.Int("action_type", info.ActionType))
+	}
+	if info.MessageID != "" {
+		attrs = append(attrs, slog.String("message_id", info.MessageID))
+	}
+	if err != nil {
+		attrs = append(attrs, slog.String("error", clean(rules.redactString(err.Error()))))
+	}
+	if config.LogBodies {
+		attrs = append(attrs,
+			slog.String("request_body", clean(rules.Redact(r.Data))),
+			slog.String("response_body", clean(rules.Redact(responseBody))),
+		)
+	}
+
+	msg := "Messaging API request"
+	if err != nil {
+		msg = "Messaging API request failed"
+	}
+	logger.LogAttrs(ctx, level, msg, attrs...)
+}
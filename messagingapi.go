@@ -10,29 +10,52 @@ import (
 // New creates a new API instance using the given config
 func New(config APIConfig) (*MessagingAPI, error) {
 
-	if config.AccessToken == "" {
+	if config.AccessToken == "" && config.TokenSource == nil {
 		return nil, errors.New("Access Token can not be blank")
 
 	}
-	if config.Endpoint == "" {
+	if config.TokenSource == nil {
+		config.TokenSource = NewStaticTokenSource(config.AccessToken)
+	}
+	if config.Endpoint == "" && len(config.Endpoints) == 0 {
 		return nil, errors.New("Endpoint can not be blank")
 	}
 
+	if len(config.Endpoints) > 0 {
+		endpoints, err := normalizeEndpoints(config.Endpoints)
+		if err != nil {
+			return nil, err
+		}
+		config.Endpoints = endpoints
+		config.Endpoint = endpoints[0]
+		if len(endpoints) > 1 {
+			config.pool = newEndpointPool(config, endpoints)
+		}
+	}
+
+	config.MMSSizeLimits = normalizeMMSSizeLimits(config.MMSSizeLimits)
+
 	// Add trailing slash if not present
 	if config.Endpoint[len(config.Endpoint)-1:] != "/" {
 		config.Endpoint = config.Endpoint + "/"
 	}
 
 	api := MessagingAPI{
-		config: config,
+		config:      config,
+		idempotency: newIdempotencyCache(config.IdempotencyTTL),
 	}
 
 	return &api, nil
 }
 
-// HandleErrorResponse checks status codes and completed the required fields
+// HandleErrorResponse checks status codes and completed the required fields.
+// Requests rejected by the CircuitBreaker get APIResultStatusesCircuitOpen,
+// the API methods also return ErrCircuitOpen for them
 func HandleErrorResponse(result APIResult, statusCode int, err error) APIResult {
-	if statusCode != http.StatusBadGateway {
+	if errors.Is(err, ErrCircuitOpen) {
+		result.StatusCode = APIResultStatusesCircuitOpen
+		result.StatusDescription = err.Error()
+	} else if statusCode != http.StatusBadGateway {
 		if statusCode == 429 {
 			result.StatusCode = APIResultStatusesRateLimited
 		} else if statusCode == http.StatusUnauthorized {
@@ -61,6 +84,9 @@ func (api *MessagingAPI) Ping() (APIResult, error) {
 	_, statusCode, err := r.Execute()
 	if err != nil {
 		result = HandleErrorResponse(result, statusCode, err)
+		if errors.Is(err, ErrCircuitOpen) {
+			return result, err
+		}
 	} else {
 		result.StatusCode = APIResultStatusesOk
 		result.StatusDescription = "Ok"
@@ -72,41 +98,19 @@ func (api *MessagingAPI) Ping() (APIResult, error) {
 // Create requests a new message to be submitted via the API
 func (api *MessagingAPI) Create(message NewMessage) (APIResult, error) {
 	result := APIResult{}
-	err := message.Validate()
+	message.prepare()
+	err := message.validate(api.config)
 	if err != nil {
 		return result, err
-	} else {
-
-		jsonBytes, err := json.Marshal(message)
-		if err != nil {
-			return result, err
-		}
-
-		r, err := NewAPIWebRequest(api.config, "message/send", "POST", string(jsonBytes))
-		if err != nil {
-			return result, err
-		}
-
-		responseBody, statusCode, err := r.Execute()
-		if err != nil {
-			result = HandleErrorResponse(result, statusCode, err)
-		} else {
-
-			var newMessageResult NewMessageResult
-			err = json.Unmarshal([]byte(responseBody), &newMessageResult)
-			if err != nil {
-				result.StatusCode = APIResultStatusesError
-				result.StatusDescription = "Unable to unmarshal result from API"
-			}
-			result.MessageResult = newMessageResult
-			result.StatusCode = APIResultStatusesOk
-			result.StatusDescription = "Ok"
-		}
+	}
 
-		return result, nil
+	jsonBytes, err := json.Marshal(message)
+	if err != nil {
+		return result, err
 	}
 
-	return result, nil
+	result, _, err = api.submitMessage("message/send", string(jsonBytes), message.IdempotencyKey)
+	return result, err
 }
 
 // Resend resubmits a message
@@ -117,13 +121,42 @@ func (api *MessagingAPI) Resend(resendRequest ResendMessageRequest) (APIResult,
 		return result, err
 	}
 
-	r, err := NewAPIWebRequest(api.config, "message/resend", "POST", string(jsonBytes))
+	result, _, err = api.submitMessage("message/resend", string(jsonBytes), resendRequest.IdempotencyKey)
+	return result, err
+}
+
+// submitMessage POSTs a packaged message to the given route and returns
+// the result along with the HTTP status code of the response. When an
+// idempotency key is given and was already sent within the TTL, the
+// original result is returned without calling the API. A request with the
+// same key that is still in flight is waited for
+func (api *MessagingAPI) submitMessage(url string, data string, idempotencyKey string) (APIResult, int, error) {
+	result := APIResult{}
+	if idempotencyKey != "" {
+		messageResult, ok, release := api.idempotency.claim(url, idempotencyKey)
+		if ok {
+			result.MessageResult = messageResult
+			result.StatusCode = APIResultStatusesOk
+			result.StatusDescription = "Ok"
+			return result, http.StatusOK, nil
+		}
+		defer release()
+	}
+
+	r, err := NewAPIWebRequest(api.config, url, "POST", data)
 	if err != nil {
-		return result, err
+		return result, 0, err
+	}
+	if idempotencyKey != "" {
+		r.Header.Set(IdempotencyKeyHeader, idempotencyKey)
 	}
+
 	responseBody, statusCode, err := r.Execute()
 	if err != nil {
 		result = HandleErrorResponse(result, statusCode, err)
+		if errors.Is(err, ErrCircuitOpen) {
+			return result, statusCode, err
+		}
 	} else {
 
 		var newMessageResult NewMessageResult
@@ -135,9 +168,13 @@ func (api *MessagingAPI) Resend(resendRequest ResendMessageRequest) (APIResult,
 		result.MessageResult = newMessageResult
 		result.StatusCode = APIResultStatusesOk
 		result.StatusDescription = "Ok"
+
+		if idempotencyKey != "" && newMessageResult.MessageID != "" {
+			api.idempotency.put(url, idempotencyKey, newMessageResult)
+		}
 	}
 
-	return result, nil
+	return result, statusCode, nil
 }
 
 // Create requests a new approval request to be submitted via the API
@@ -156,6 +193,9 @@ func (api *MessagingAPI) CreateApproval(approvalRequest ApprovalRequest) (APIRes
 	responseBody, statusCode, err := r.Execute()
 	if err != nil {
 		result = HandleErrorResponse(result, statusCode, err)
+		if errors.Is(err, ErrCircuitOpen) {
+			return result, err
+		}
 	} else {
 
 		var requestResult ApprovalRequestResult
@@ -187,6 +227,9 @@ func (api *MessagingAPI) UpdateApproval(updateRequest ApprovalUpdateRequest) (AP
 	responseBody, statusCode, err := r.Execute()
 	if err != nil {
 		result = HandleErrorResponse(result, statusCode, err)
+		if errors.Is(err, ErrCircuitOpen) {
+			return result, err
+		}
 	} else {
 
 		var requestResult ApprovalRequestResult
@@ -218,6 +261,9 @@ func (api *MessagingAPI) GetMessageStatus(message_id string) (APIResult, error)
 	responseBody, statusCode, err := r.Execute()
 	if err != nil {
 		result = HandleErrorResponse(result, statusCode, err)
+		if errors.Is(err, ErrCircuitOpen) {
+			return result, err
+		}
 	} else {
 
 		var status StatusResult
@@ -240,40 +286,19 @@ func (api *MessagingAPI) Generate(request BuildRequest) (APIResult, error) {
 	err := request.Validate()
 	if err != nil {
 		return result, err
-	} else {
-		messageJson, err := request.Package()
-		if err != nil {
-			return result, err
-		}
-
-		r, err := NewAPIWebRequest(api.config, "generate/video", "POST", messageJson)
-		if err != nil {
-			return result, err
-		}
-
-		responseBody, statusCode, err := r.Execute()
-		if err != nil {
-			result = HandleErrorResponse(result, statusCode, err)
-		} else {
-
-			var newMessageResult NewMessageResult
-			err = json.Unmarshal([]byte(responseBody), &newMessageResult)
-			if err != nil {
-				result.StatusCode = APIResultStatusesError
-				result.StatusDescription = "Unable to unmarshal result from API"
-			}
-			result.MessageResult = newMessageResult
-			result.StatusCode = APIResultStatusesOk
-			result.StatusDescription = "Ok"
-		}
+	}
 
-		return result, nil
+	messageJson, err := request.Package()
+	if err != nil {
+		return result, err
 	}
 
-	return result, nil
+	result, _, err = api.submitMessage("generate/video", messageJson, request.IdempotencyKey)
+	return result, err
 }
 
-// GetMSISDNScrub retrieves the MSISDN's handset information
+// GetMSISDNScrub retrieves the MSISDN's handset information, from the
+// ScrubCache when configured
 func (api *MessagingAPI) GetMSISDNScrub(msisdn string) (APIResult, error) {
 	result := APIResult{}
 
@@ -281,6 +306,15 @@ func (api *MessagingAPI) GetMSISDNScrub(msisdn string) (APIResult, error) {
 		return result, errors.New("msisdn must not be blank")
 	}
 
+	if api.config.ScrubCache != nil {
+		if scrubres, ok := api.config.ScrubCache.Get(msisdn); ok {
+			result.ScrubResult = scrubres
+			result.StatusCode = APIResultStatusesOk
+			result.StatusDescription = "Ok"
+			return result, nil
+		}
+	}
+
 	r, err := NewAPIWebRequest(api.config, "scrub/"+msisdn, "GET", "")
 	if err != nil {
 		return result, err
@@ -289,6 +323,9 @@ func (api *MessagingAPI) GetMSISDNScrub(msisdn string) (APIResult, error) {
 	responseBody, statusCode, err := r.Execute()
 	if err != nil {
 		result = HandleErrorResponse(result, statusCode, err)
+		if errors.Is(err, ErrCircuitOpen) {
+			return result, err
+		}
 	} else {
 
 		var scrubres ScrubResult
@@ -296,11 +333,17 @@ func (api *MessagingAPI) GetMSISDNScrub(msisdn string) (APIResult, error) {
 		if err != nil {
 			result.StatusCode = APIResultStatusesError
 			result.StatusDescription = "Unable to unmarshal result from API"
+			return result, nil
 		}
 
 		result.ScrubResult = scrubres
 		result.StatusCode = APIResultStatusesOk
 		result.StatusDescription = "Ok"
+
+		// The cache is best effort, a failed store save doesn't fail the scrub
+		if api.config.ScrubCache != nil {
+			api.config.ScrubCache.Put(msisdn, scrubres)
+		}
 	}
 	return result, nil
 }
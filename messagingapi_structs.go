@@ -2,12 +2,14 @@
 package messagingapi
 
 import (
+	"net/http"
 	"time"
 )
 
 // MessagingAPI is the primary object to work with the API
 type MessagingAPI struct {
-	config APIConfig
+	config      APIConfig
+	idempotency *idempotencyCache
 }
 
 // APIWebRequest handles all API communication
@@ -16,12 +18,82 @@ type APIWebRequest struct {
 	Url    string
 	Method string
 	Data   string
+	// Header holds extra headers sent with the request
+	Header http.Header
+	// Info describes the request for logging and instrumentation
+	Info RequestInfo
+}
+
+// RequestInfo describes an API request, it is derived from the route
+// and request body when the request is created
+type RequestInfo struct {
+	// Route is the logical route, one of Route*
+	Route string
+	// Method is the HTTP method
+	Method string
+	// MVNOID the request belongs to, zero if unknown
+	MVNOID int
+	// Campaign the request belongs to, blank if unknown
+	Campaign string
+	// ActionType is the Action, AfterBuildAction or approval ActionType
+	ActionType int
+	// MessageID of the message, set from the route or the API response
+	MessageID string
+	// RequestID is sent in the X-Request-ID header to correlate attempts
+	RequestID string
+	// Attempt is the HTTP call number of this request, starting at 1
+	Attempt int
+	// Endpoint the current attempt is sent to
+	Endpoint string
 }
 
 // APIConfig is the API configuration passed when creating new API instance
 type APIConfig struct {
-	Endpoint    string
+	Endpoint string
+	// Endpoints is an ordered list of endpoints to fail over between, most
+	// preferred first. When set, Endpoint may be left blank (optional)
+	Endpoints   []string
 	AccessToken string
+	// TokenSource supplies the access token for every request, when set
+	// AccessToken may be left blank (optional)
+	TokenSource TokenSource
+	// MaxRetries is the amount of times a transient failure is retried.
+	// Only GET requests and requests with an idempotency key are retried
+	MaxRetries int
+	// RetryDelay is the delay before the first retry, doubled on every
+	// following retry. Defaults to 1 second
+	RetryDelay time.Duration
+	// IdempotencyTTL is how long the result of a request with an
+	// idempotency key is remembered, defaults to 24 hours
+	IdempotencyTTL time.Duration
+	// Log enables request logging (optional)
+	Log *LogConfig
+	// Instrumentation receives hooks around every HTTP call (optional)
+	Instrumentation Instrumentation
+	// Middleware wraps every request, outermost first (optional)
+	Middleware []Middleware
+	// CircuitBreaker fails requests fast while the API is degraded (optional)
+	CircuitBreaker *CircuitBreaker
+	// FailoverThreshold is the amount of consecutive failed calls and
+	// health checks before an endpoint is marked unhealthy, defaults to 3
+	FailoverThreshold int
+	// MMSSizeLimits overrides the maximum MMS size in bytes per network,
+	// keyed by the SubmitMMSMessageData.Network value (optional)
+	MMSSizeLimits map[string]int
+	// EmailAttachmentLimit is the maximum total decoded size in bytes of the
+	// attachments of an email, defaults to DefaultEmailAttachmentLimit
+	EmailAttachmentLimit int
+	// EmailMaxRecipients is the amount of Address recipients accepted per
+	// email, defaults to DefaultEmailMaxRecipients. Use CreateFanOut to
+	// send to more
+	EmailMaxRecipients int
+	// ScrubCache caches the results of GetMSISDNScrub (optional)
+	ScrubCache *ScrubCache
+	// HealthCheckInterval is how often every endpoint is pinged when
+	// Endpoints is set, defaults to 30 seconds. Negative disables checks
+	HealthCheckInterval time.Duration
+
+	pool *endpointPool
 }
 
 // NewMessage is the wrapper struct to submit a new message to the API
@@ -48,6 +120,9 @@ type NewMessage struct {
 	SubmitNotBefore string
 	// This message should not be submitted after this date, format yyyy-mm-dd hh:mm
 	SubmitNotAfter string
+	// IdempotencyKey identifies this logical send, a repeated key returns
+	// the original result instead of sending again (optional)
+	IdempotencyKey string `json:"-"`
 
 	Error string
 }
@@ -66,6 +141,9 @@ type ResendMessageRequest struct {
 	// Possible values of "build", "submit", "archive", "sent", "delivery"
 	// comma delimited - i.e. "build,submit,delivery"
 	PostbackStatusTypes string
+	// IdempotencyKey identifies this logical resend, a repeated key returns
+	// the original result instead of sending again (optional)
+	IdempotencyKey string `json:"-"`
 	// The last error
 	Error string
 }
@@ -83,6 +161,7 @@ type APIResult struct {
 	MessageStatus     StatusResult
 	RequestResult     ApprovalRequestResult
 	ScrubResult       ScrubResult
+	ApprovalStatus    ApprovalStatus
 	//ArchivedMessage ArchivedMessage;
 }
 
@@ -142,18 +221,33 @@ type SubmitEmailMessageData struct {
 	Address     []string `json:"address"`
 	MSISDN      []string
 	Network     string
-	Subject     string            `json:"subject"`
-	HTML        string            `json:"html"`
-	Text        string            `json:"text"`
-	FromName    string            `json:"fromname"`
-	ReplyTo     string            `json:"replyto"`
+	Subject     string `json:"subject"`
+	HTML        string `json:"html"`
+	Text        string `json:"text"`
+	FromName    string `json:"fromname"`
+	ReplyTo     string `json:"replyto"`
+	// CC and BCC recipients, as RFC 5322 addresses (optional)
+	CC          []string          `json:"cc,omitempty"`
+	BCC         []string          `json:"bcc,omitempty"`
 	Attachments []EmailAttachment `json:"attachments"`
+	// GenerateText renders Text from HTML when Text is blank
+	GenerateText bool `json:"-"`
+	// InlineCSS moves the rules of style blocks in HTML into style
+	// attributes before sending
+	InlineCSS bool `json:"-"`
 }
 
 // EmailAttachment holds the structure of the attachment data
 type EmailAttachment struct {
 	Filename string `json:"filename"`
-	Data     string `json:"data"`
+	// Data is the base64 encoded content
+	Data string `json:"data"`
+	// ContentType is the MIME type of the content (optional)
+	ContentType string `json:"content_type,omitempty"`
+	// Disposition is one of EmailDisposition*, defaults to attachment
+	Disposition string `json:"disposition,omitempty"`
+	// ContentID identifies an inline attachment in the HTML body
+	ContentID string `json:"content_id,omitempty"`
 }
 
 // IncomingSMS is the structure returned from the
@@ -218,22 +312,6 @@ type StatusResult struct {
 	PostbackType               string    `json:"postback_type,omitempty"`
 }
 
-type ScrubResult struct {
-	Network      string        `json:"network"`
-	MSISDN       string        `json:"msisdn"`
-	HandsetMake  string        `json:"handset_make"`
-	HandsetModel string        `json:"handset_model"`
-	AllowSend    string        `json:allow_send`
-	ScreenSize   ScreenSizeObj `json:"screen_size"`
-	ErrorCode    string        `json:"error_code"`
-	Error        string        `json:"error"`
-}
-
-type ScreenSizeObj struct {
-	Width  string `json:"width"`
-	Height string `json:"height"`
-}
-
 // The person appprovals should be sent to
 type ApprovalPerson struct {
 	Name   string `json:"name"`
@@ -263,6 +341,10 @@ type ApprovalRequest struct {
 	// If this is linked to another approval, the approvals
 	// will not be sent until all linked has been marked ready
 	LinkedApproval uint32 `json:"linked_approval,omitempty"`
+	// A URL where an ApprovalEvent is POSTed for every approver
+	// response and state change (optional). Provisional until the
+	// API documents approval postbacks
+	PostbackUrl string `json:"postback_url,omitempty"`
 }
 
 // ApprovalRequestResult The result of the approval request
@@ -301,6 +383,7 @@ const (
 	APIResultStatusesInvalidMethod = 3
 	APIResultStatusesAPIError      = 4
 	APIResultStatusesRateLimited   = 5
+	APIResultStatusesCircuitOpen   = 6
 )
 
 const (
@@ -313,6 +396,20 @@ const (
 	APIActionTypesArchiveEmail = 10
 )
 
+// Logical routes reported in RequestInfo
+const (
+	RoutePing           = "ping"
+	RouteSend           = "send"
+	RouteResend         = "resend"
+	RouteStatus         = "status"
+	RouteScrub          = "scrub"
+	RouteApprovalCreate = "approval_create"
+	RouteApprovalUpdate = "approval_update"
+	RouteApprovalStatus = "approval_status"
+	RouteGenerate       = "generate"
+	RouteOther          = "other"
+)
+
 const (
 	MMSContentTypeText  = "text"
 	MMSContentTypeImage = "image"
@@ -0,0 +1,220 @@
+package messagingapi
+
+import (
+	"errors"
+	"net/http"
+	"sync"
+	"time"
+)
+
+// RequestHandler sends an APIWebRequest, returning the response body,
+// HTTP status code and error in the same way as Execute
+type RequestHandler func(r *APIWebRequest) (string, int, error)
+
+// Middleware wraps a RequestHandler. A middleware may inspect and modify
+// the request's Data and Header before calling next, and observe the
+// response it returns
+type Middleware func(next RequestHandler) RequestHandler
+
+// handler builds the middleware chain for the config. APIConfig.Middleware
+// is outermost, followed by the middlewares enabled by the other config
+// fields, ending with the HTTP call
+func (config APIConfig) handler() RequestHandler {
+	var middlewares []Middleware
+	middlewares = append(middlewares, config.Middleware...)
+	if config.Log != nil {
+		middlewares = append(middlewares, LoggingMiddleware(config.Log))
+	}
+	if config.CircuitBreaker != nil {
+		middlewares = append(middlewares, config.CircuitBreaker.Middleware())
+	}
+	if config.MaxRetries > 0 {
+		middlewares = append(middlewares, RetryMiddleware(config.MaxRetries, config.RetryDelay))
+	}
+	middlewares = append(middlewares, TokenRefreshMiddleware())
+	if config.pool != nil {
+		middlewares = append(middlewares, config.pool.middleware)
+	}
+	middlewares = append(middlewares, countAttempts)
+	if config.Instrumentation != nil {
+		middlewares = append(middlewares, InstrumentationMiddleware(config.Instrumentation))
+	}
+
+	handler := RequestHandler((*APIWebRequest).execute)
+	for i := len(middlewares) - 1; i >= 0; i-- {
+		handler = middlewares[i](handler)
+	}
+	return handler
+}
+
+// countAttempts numbers every HTTP call made for a request
+func countAttempts(next RequestHandler) RequestHandler {
+	return func(r *APIWebRequest) (string, int, error) {
+		r.Info.Attempt++
+		return next(r)
+	}
+}
+
+// HeaderMiddleware adds the given headers to every request
+func HeaderMiddleware(header http.Header) Middleware {
+	return func(next RequestHandler) RequestHandler {
+		return func(r *APIWebRequest) (string, int, error) {
+			for key, values := range header {
+				r.Header.Del(key)
+				for _, value := range values {
+					r.Header.Add(key, value)
+				}
+			}
+			return next(r)
+		}
+	}
+}
+
+// LoggingMiddleware logs every request once it completed, including the
+// amount of attempts it took
+func LoggingMiddleware(config *LogConfig) Middleware {
+	return func(next RequestHandler) RequestHandler {
+		return func(r *APIWebRequest) (string, int, error) {
+			start := time.Now()
+			body, statusCode, err := next(r)
+			config.logRequest(r, body, statusCode, err, time.Since(start))
+			return body, statusCode, err
+		}
+	}
+}
+
+// RetryMiddleware retries transient failures up to maxRetries times,
+// doubling delay after every retry. Only GET requests and requests with
+// an Idempotency-Key header are retried. A delay of zero defaults to
+// 1 second
+func RetryMiddleware(maxRetries int, delay time.Duration) Middleware {
+	if delay <= 0 {
+		delay = time.Second
+	}
+	return func(next RequestHandler) RequestHandler {
+		return func(r *APIWebRequest) (string, int, error) {
+			retries := 0
+			if r.Method == "GET" || r.Header.Get(IdempotencyKeyHeader) != "" {
+				retries = maxRetries
+			}
+
+			wait := delay
+			for attempt := 0; ; attempt++ {
+				body, statusCode, err := next(r)
+				if err == nil || attempt >= retries || !retryableStatus(statusCode) {
+					return body, statusCode, err
+				}
+				time.Sleep(wait)
+				wait *= 2
+			}
+		}
+	}
+}
+
+// TokenRefreshMiddleware refreshes the access token and sends the request
+// once more when the API rejects the token, if the config's TokenSource
+// implements TokenRefresher
+func TokenRefreshMiddleware() Middleware {
+	return func(next RequestHandler) RequestHandler {
+		return func(r *APIWebRequest) (string, int, error) {
+			body, statusCode, err := next(r)
+			if statusCode != http.StatusUnauthorized {
+				return body, statusCode, err
+			}
+
+			refresher, ok := r.config.TokenSource.(TokenRefresher)
+			if !ok {
+				return body, statusCode, err
+			}
+			if _, refreshErr := refresher.Refresh(); refreshErr != nil {
+				return body, statusCode, err
+			}
+			return next(r)
+		}
+	}
+}
+
+// InstrumentationMiddleware calls the Instrumentation hooks around the
+// rest of the chain
+func InstrumentationMiddleware(instrumentation Instrumentation) Middleware {
+	return func(next RequestHandler) RequestHandler {
+		return func(r *APIWebRequest) (string, int, error) {
+			call := instrumentation.BeforeRequest(r.Info)
+			if injector, ok := call.(HeaderInjector); ok {
+				injector.InjectHeader(r.Header)
+			}
+			start := time.Now()
+			body, statusCode, err := next(r)
+			instrumentation.AfterRequest(r.Info, call, RequestOutcome{
+				StatusCode: statusCode,
+				ErrorClass: ClassifyError(statusCode, err),
+				Err:        err,
+				Duration:   time.Since(start),
+			})
+			return body, statusCode, err
+		}
+	}
+}
+
+// RateLimitMiddleware delays requests so no more than perSecond requests
+// are sent on average, allowing bursts of up to burst requests
+func RateLimitMiddleware(perSecond float64, burst int) Middleware {
+	limiter := newRateLimiter(perSecond, burst)
+	return func(next RequestHandler) RequestHandler {
+		return func(r *APIWebRequest) (string, int, error) {
+			if limiter == nil {
+				return "", 0, errors.New("Rate limit must be greater than zero")
+			}
+			limiter.wait()
+			return next(r)
+		}
+	}
+}
+
+// rateLimiter is a token bucket shared by all requests of a middleware
+type rateLimiter struct {
+	lock      sync.Mutex
+	perSecond float64
+	burst     float64
+	tokens    float64
+	last      time.Time
+}
+
+// newRateLimiter creates a full token bucket, nil if perSecond is invalid
+func newRateLimiter(perSecond float64, burst int) *rateLimiter {
+	if perSecond <= 0 {
+		return nil
+	}
+	if burst < 1 {
+		burst = 1
+	}
+	return &rateLimiter{
+		perSecond: perSecond,
+		burst:     float64(burst),
+		tokens:    float64(burst),
+		last:      time.Now(),
+	}
+}
+
+// wait takes a token, sleeping until one is available
+func (l *rateLimiter) wait() {
+	l.lock.Lock()
+	now := time.Now()
+	l.tokens += now.Sub(l.last).Seconds() * l.perSecond
+	if l.tokens > l.burst {
+		l.tokens = l.burst
+	}
+	l.last = now
+
+	// Reserve the token now, callers queue up behind each other
+	l.tokens--
+	var delay time.Duration
+	if l.tokens < 0 {
+		delay = time.Duration(-l.tokens / l.perSecond * float64(time.Second))
+	}
+	l.lock.Unlock()
+
+	if delay > 0 {
+		time.Sleep(delay)
+	}
+}
@@ -0,0 +1,266 @@
+package messagingapi
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"io"
+	"mime"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+// MMSSlideBuilder builds an MMSSlide from text and media, sniffing the
+// MIME type of the media and enforcing the slide composition rules
+// carriers require: at most one text, one image or video, and one audio
+// per slide, and no audio alongside a video. The first error is kept and
+// returned by Build
+type MMSSlideBuilder struct {
+	slide MMSSlide
+	err   error
+}
+
+// NewMMSSlideBuilder creates a builder for a slide shown for 10 seconds
+func NewMMSSlideBuilder() *MMSSlideBuilder {
+	return &MMSSlideBuilder{
+		slide: MMSSlide{Duration: "10"},
+	}
+}
+
+// SetDuration sets how many seconds the slide is shown for
+func (b *MMSSlideBuilder) SetDuration(seconds int) *MMSSlideBuilder {
+	if b.err != nil {
+		return b
+	}
+	if seconds <= 0 {
+		b.err = errors.New("Slide duration must be greater than zero")
+		return b
+	}
+	b.slide.Duration = strconv.Itoa(seconds)
+	return b
+}
+
+// AddText adds the text of the slide. Text content is sent as is, not
+// base64 encoded
+func (b *MMSSlideBuilder) AddText(text string) *MMSSlideBuilder {
+	if b.err != nil {
+		return b
+	}
+	if text == "" {
+		b.err = errors.New("Slide text can not be blank")
+		return b
+	}
+	return b.add(MMSSlideContent{
+		Type: MMSContentTypeText,
+		Mime: "text/plain",
+		Name: "text.txt",
+		Data: text,
+	})
+}
+
+// AddImage adds the image of the slide read from r
+func (b *MMSSlideBuilder) AddImage(r io.Reader, name string) *MMSSlideBuilder {
+	return b.addMedia(r, name, MMSContentTypeImage)
+}
+
+// AddAudio adds the audio of the slide read from r
+func (b *MMSSlideBuilder) AddAudio(r io.Reader, name string) *MMSSlideBuilder {
+	return b.addMedia(r, name, MMSContentTypeAudio)
+}
+
+// AddVideo adds the video of the slide read from r
+func (b *MMSSlideBuilder) AddVideo(r io.Reader, name string) *MMSSlideBuilder {
+	return b.addMedia(r, name, MMSContentTypeVideo)
+}
+
+// AddFile adds the image, audio, video or text file at path, the content
+// type is chosen from the sniffed MIME type
+func (b *MMSSlideBuilder) AddFile(path string) *MMSSlideBuilder {
+	if b.err != nil {
+		return b
+	}
+	file, err := os.Open(path)
+	if err != nil {
+		b.err = err
+		return b
+	}
+	defer file.Close()
+
+	return b.addMedia(file, filepath.Base(path), "")
+}
+
+// Build returns the slide, or the first error encountered
+func (b *MMSSlideBuilder) Build() (MMSSlide, error) {
+	if b.err != nil {
+		return MMSSlide{}, b.err
+	}
+	if len(b.slide.Content) == 0 {
+		return MMSSlide{}, errors.New("A slide must have at least one content item")
+	}
+	return b.slide, nil
+}
+
+// addMedia sniffs and base64 encodes the content of r. When contentType
+// is blank it is chosen from the MIME type
+func (b *MMSSlideBuilder) addMedia(r io.Reader, name string, contentType string) *MMSSlideBuilder {
+	if b.err != nil {
+		return b
+	}
+	if name == "" {
+		b.err = errors.New("Slide content name can not be blank")
+		return b
+	}
+
+	reader := bufio.NewReaderSize(r, 512)
+	head, err := reader.Peek(512)
+	if err != nil && err != io.EOF && err != bufio.ErrBufferFull {
+		b.err = err
+		return b
+	}
+	if len(head) == 0 {
+		b.err = fmt.Errorf("Slide content %s is empty", name)
+		return b
+	}
+
+	mimeType := SniffMIMEType(head, name)
+	sniffedType := mmsContentType(mimeType)
+	if sniffedType == "" {
+		b.err = fmt.Errorf("Slide content %s has unsupported type %s", name, mimeType)
+		return b
+	}
+	if contentType == "" {
+		contentType = sniffedType
+	} else if contentType != sniffedType {
+		b.err = fmt.Errorf("Slide content %s is %s, not %s", name, mimeType, contentType)
+		return b
+	}
+
+	var data strings.Builder
+	if contentType == MMSContentTypeText {
+		_, err = io.Copy(&data, reader)
+	} else {
+		encoder := base64.NewEncoder(base64.StdEncoding, &data)
+		_, err = io.Copy(encoder, reader)
+		if err == nil {
+			err = encoder.Close()
+		}
+	}
+	if err != nil {
+		b.err = err
+		return b
+	}
+
+	return b.add(MMSSlideContent{
+		Type: contentType,
+		Mime: mimeType,
+		Name: name,
+		Data: data.String(),
+	})
+}
+
+// add appends the content if the slide composition rules allow it
+func (b *MMSSlideBuilder) add(content MMSSlideContent) *MMSSlideBuilder {
+	counts := make(map[string]int)
+	for _, existing := range b.slide.Content {
+		counts[existing.Type]++
+	}
+
+	switch content.Type {
+	case MMSContentTypeText:
+		if counts[MMSContentTypeText] > 0 {
+			b.err = errors.New("A slide can only have one text")
+		}
+	case MMSContentTypeImage, MMSContentTypeVideo:
+		if counts[MMSContentTypeImage]+counts[MMSContentTypeVideo] > 0 {
+			b.err = errors.New("A slide can only have one image or video")
+		}
+		if content.Type == MMSContentTypeVideo && counts[MMSContentTypeAudio] > 0 {
+			b.err = errors.New("A slide with audio can not have a video")
+		}
+	case MMSContentTypeAudio:
+		if counts[MMSContentTypeAudio] > 0 {
+			b.err = errors.New("A slide can only have one audio")
+		}
+		if counts[MMSContentTypeVideo] > 0 {
+			b.err = errors.New("A slide with a video can not have audio")
+		}
+	}
+	if b.err != nil {
+		return b
+	}
+
+	b.slide.Content = append(b.slide.Content, content)
+	return b
+}
+
+// AddSlide builds the slide and appends it to the message. Content names
+// must be unique within a message, so repeated names are prefixed with
+// the slide number
+func (data *SubmitMMSMessageData) AddSlide(builder *MMSSlideBuilder) error {
+	slide, err := builder.Build()
+	if err != nil {
+		return err
+	}
+
+	names := make(map[string]bool)
+	for _, existing := range data.Slides {
+		for _, content := range existing.Content {
+			names[content.Name] = true
+		}
+	}
+	for i := range slide.Content {
+		if names[slide.Content[i].Name] {
+			slide.Content[i].Name = fmt.Sprintf("s%d_%s", len(data.Slides)+1, slide.Content[i].Name)
+		}
+		names[slide.Content[i].Name] = true
+	}
+
+	data.Slides = append(data.Slides, slide)
+	return nil
+}
+
+// SniffMIMEType returns the MIME type of content from its first bytes,
+// falling back to the extension of name when the content isn't recognised
+func SniffMIMEType(head []byte, name string) string {
+	// Formats common on handsets which http.DetectContentType doesn't know
+	switch {
+	case bytes.HasPrefix(head, []byte("#!AMR")):
+		return "audio/amr"
+	case len(head) >= 12 && string(head[4:8]) == "ftyp" && strings.HasPrefix(string(head[8:12]), "3gp"):
+		return "video/3gpp"
+	}
+
+	mimeType := http.DetectContentType(head)
+	if mimeType == "application/octet-stream" || strings.HasPrefix(mimeType, "text/plain") {
+		if byExtension := mime.TypeByExtension(filepath.Ext(name)); byExtension != "" {
+			mimeType = byExtension
+		}
+	}
+
+	// Drop parameters such as charset
+	if mediaType, _, err := mime.ParseMediaType(mimeType); err == nil {
+		return mediaType
+	}
+	return mimeType
+}
+
+// mmsContentType returns the MMSContentType* for a MIME type, blank if
+// the MIME type can't be sent in an MMS
+func mmsContentType(mimeType string) string {
+	switch {
+	case mimeType == "text/plain":
+		return MMSContentTypeText
+	case strings.HasPrefix(mimeType, "image/"):
+		return MMSContentTypeImage
+	case strings.HasPrefix(mimeType, "audio/"):
+		return MMSContentTypeAudio
+	case strings.HasPrefix(mimeType, "video/"):
+		return MMSContentTypeVideo
+	}
+	return ""
+}
@@ -0,0 +1,286 @@
+package messagingapi
+
+import (
+	"bytes"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"image"
+	"image/color"
+	"image/draw"
+	"image/gif"
+	"image/jpeg"
+	_ "image/png"
+	"path/filepath"
+	"strings"
+)
+
+// MMSImageOptions configures OptimizeImages
+type MMSImageOptions struct {
+	// MaxWidth and MaxHeight bound the size of images in pixels, zero
+	// leaves that dimension unbounded
+	MaxWidth  int
+	MaxHeight int
+	// Quality is the JPEG quality images are encoded at first, defaults to 85
+	Quality int
+	// MinQuality is the lowest JPEG quality stepped down to, defaults to 30
+	MinQuality int
+	// QualityStep is how much the quality is lowered each step, defaults to 10
+	QualityStep int
+	// SizeLimit is the size the message must fit in, in bytes. Defaults to
+	// the built in MMSSizeLimit of the message's network, CreateAdaptive
+	// uses the limit of the APIConfig
+	SizeLimit int
+}
+
+// MMSImageOptionsForScreen returns options bounding images to a handset's
+// screen, as reported by GetMSISDNScrub. Unknown dimensions are unbounded
+func MMSImageOptionsForScreen(screen ScreenSizeObj) MMSImageOptions {
+	return MMSImageOptions{
+		MaxWidth:  screen.Width,
+		MaxHeight: screen.Height,
+	}
+}
+
+// mmsImage is a decoded image content item of a message
+type mmsImage struct {
+	slide   int
+	content int
+	img     image.Image
+	resized bool
+}
+
+// OptimizeImages downscales JPEG, PNG and GIF images to the maximum
+// dimensions keeping their aspect ratio, and steps the JPEG quality down
+// until the message fits the size limit. Optimized images are re-encoded
+// as JPEG and renamed to .jpg, prefixed with the slide number when that
+// name is already used in the message. Animated GIFs, images in other
+// formats such as WebP or BMP and other media are left as is. When the
+// message can't be made to fit, it keeps the smallest encoding tried
+func (data *SubmitMMSMessageData) OptimizeImages(options MMSImageOptions) error {
+	if options.Quality <= 0 || options.Quality > 100 {
+		options.Quality = 85
+	}
+	if options.MinQuality <= 0 {
+		options.MinQuality = 30
+	}
+	if options.MinQuality > options.Quality {
+		options.MinQuality = options.Quality
+	}
+	if options.QualityStep <= 0 {
+		options.QualityStep = 10
+	}
+	if options.SizeLimit <= 0 {
+		options.SizeLimit = MMSSizeLimit(data.Network)
+	}
+
+	var images []mmsImage
+	for i, slide := range data.Slides {
+		for j, content := range slide.Content {
+			if content.Type != MMSContentTypeImage {
+				continue
+			}
+			img, err := decodeMMSImage(content)
+			if err != nil {
+				return fmt.Errorf("Unable to decode image %s: %s", content.Name, err.Error())
+			}
+			if img == nil {
+				continue
+			}
+			resized := resizeToFit(img, options.MaxWidth, options.MaxHeight)
+			images = append(images, mmsImage{
+				slide:   i,
+				content: j,
+				img:     resized,
+				resized: resized != img,
+			})
+		}
+	}
+
+	overBudget := data.SizeReport().Total > options.SizeLimit
+	anyResized := false
+	for _, item := range images {
+		anyResized = anyResized || item.resized
+	}
+	if !overBudget && !anyResized {
+		return nil
+	}
+
+	original := make([][]MMSSlideContent, len(data.Slides))
+	for i, slide := range data.Slides {
+		original[i] = append([]MMSSlideContent(nil), slide.Content...)
+	}
+
+	quality := options.Quality
+	for {
+		for _, item := range images {
+			if !item.resized && !overBudget {
+				continue
+			}
+			before := original[item.slide][item.content]
+			after, err := encodeMMSImage(before, item.img, quality)
+			if err != nil {
+				return err
+			}
+			// Keep an untouched image if re-encoding doesn't make it smaller
+			if !item.resized && after.DecodedSize() >= before.DecodedSize() {
+				after = before
+			}
+			if after.Name != before.Name {
+				after.Name = data.uniqueContentName(item.slide, item.content, after.Name)
+			}
+			data.Slides[item.slide].Content[item.content] = after
+		}
+
+		total := data.SizeReport().Total
+		if total <= options.SizeLimit {
+			return nil
+		}
+		overBudget = true
+		if quality <= options.MinQuality {
+			return fmt.Errorf("MMS message is %d bytes at JPEG quality %d, over the %d byte limit", total, quality, options.SizeLimit)
+		}
+		quality -= options.QualityStep
+		if quality < options.MinQuality {
+			quality = options.MinQuality
+		}
+	}
+}
+
+// decodeMMSImage decodes the image content, nil for animated GIFs and
+// formats without a decoder
+func decodeMMSImage(content MMSSlideContent) (image.Image, error) {
+	raw, err := base64.StdEncoding.DecodeString(content.Data)
+	if err != nil {
+		return nil, err
+	}
+
+	if content.Mime == "image/gif" {
+		animation, err := gif.DecodeAll(bytes.NewReader(raw))
+		if err != nil {
+			return nil, err
+		}
+		if len(animation.Image) > 1 {
+			return nil, nil
+		}
+	}
+
+	img, _, err := image.Decode(bytes.NewReader(raw))
+	if errors.Is(err, image.ErrFormat) {
+		return nil, nil
+	}
+	return img, err
+}
+
+// encodeMMSImage returns the content with the image encoded as JPEG and
+// its name changed to a .jpg extension
+func encodeMMSImage(content MMSSlideContent, img image.Image, quality int) (MMSSlideContent, error) {
+	// JPEG has no transparency, flatten the image onto white
+	bounds := img.Bounds()
+	flat := image.NewRGBA(bounds)
+	draw.Draw(flat, bounds, image.NewUniform(color.White), image.Point{}, draw.Src)
+	draw.Draw(flat, bounds, img, bounds.Min, draw.Over)
+
+	var out bytes.Buffer
+	err := jpeg.Encode(&out, flat, &jpeg.Options{Quality: quality})
+	if err != nil {
+		return content, err
+	}
+
+	content.Mime = "image/jpeg"
+	content.Name = strings.TrimSuffix(content.Name, filepath.Ext(content.Name)) + ".jpg"
+	content.Data = base64.StdEncoding.EncodeToString(out.Bytes())
+	return content, nil
+}
+
+// uniqueContentName returns name if no other content in the message has
+// it, otherwise it is prefixed with the slide number as AddSlide does
+func (data *SubmitMMSMessageData) uniqueContentName(slide int, content int, name string) string {
+	taken := func(name string) bool {
+		for i, existing := range data.Slides {
+			for j, other := range existing.Content {
+				if (i != slide || j != content) && other.Name == name {
+					return true
+				}
+			}
+		}
+		return false
+	}
+
+	unique := name
+	for n := 1; taken(unique); n++ {
+		unique = fmt.Sprintf("s%d_%s", slide+1, name)
+		if n > 1 {
+			unique = fmt.Sprintf("s%d_%d_%s", slide+1, n, name)
+		}
+	}
+	return unique
+}
+
+// resizeToFit scales the image down to fit within maxWidth and maxHeight,
+// keeping the aspect ratio. The image is returned as is if it fits
+func resizeToFit(img image.Image, maxWidth int, maxHeight int) image.Image {
+	bounds := img.Bounds()
+	width, height := bounds.Dx(), bounds.Dy()
+	if width == 0 || height == 0 {
+		return img
+	}
+
+	scale := 1.0
+	if maxWidth > 0 && width > maxWidth {
+		scale = float64(maxWidth) / float64(width)
+	}
+	if maxHeight > 0 && height > maxHeight {
+		if s := float64(maxHeight) / float64(height); s < scale {
+			scale = s
+		}
+	}
+	if scale >= 1 {
+		return img
+	}
+
+	newWidth := int(float64(width)*scale + 0.5)
+	newHeight := int(float64(height)*scale + 0.5)
+	if newWidth < 1 {
+		newWidth = 1
+	}
+	if newHeight < 1 {
+		newHeight = 1
+	}
+
+	// Average the source pixels covered by every destination pixel
+	resized := image.NewRGBA(image.Rect(0, 0, newWidth, newHeight))
+	for y := 0; y < newHeight; y++ {
+		y0 := bounds.Min.Y + y*height/newHeight
+		y1 := bounds.Min.Y + (y+1)*height/newHeight
+		if y1 <= y0 {
+			y1 = y0 + 1
+		}
+		for x := 0; x < newWidth; x++ {
+			x0 := bounds.Min.X + x*width/newWidth
+			x1 := bounds.Min.X + (x+1)*width/newWidth
+			if x1 <= x0 {
+				x1 = x0 + 1
+			}
+
+			var r, g, b, a, count uint64
+			for sy := y0; sy < y1; sy++ {
+				for sx := x0; sx < x1; sx++ {
+					cr, cg, cb, ca := img.At(sx, sy).RGBA()
+					r += uint64(cr)
+					g += uint64(cg)
+					b += uint64(cb)
+					a += uint64(ca)
+					count++
+				}
+			}
+			resized.Set(x, y, color.RGBA64{
+				R: uint16(r / count),
+				G: uint16(g / count),
+				B: uint16(b / count),
+				A: uint16(a / count),
+			})
+		}
+	}
+	return resized
+}
@@ -0,0 +1,167 @@
+package messagingapi
+
+import (
+	"sort"
+	"strings"
+)
+
+// DefaultMMSSizeLimit is the size limit in bytes used for networks
+// without a limit of their own
+const DefaultMMSSizeLimit = 300 * 1024
+
+// Estimated envelope overhead of an MMS, in bytes
+const (
+	// MM1 headers, addresses and the SMIL document
+	mmsMessageOverhead = 1024
+	// SMIL markup per slide
+	mmsSlideOverhead = 128
+	// Multipart headers per content item
+	mmsContentOverhead = 160
+)
+
+// mmsSizeLimits is the built in maximum MMS size in bytes per network
+var mmsSizeLimits = map[string]int{
+	"mtn":     300 * 1024,
+	"vodacom": 300 * 1024,
+	"cellc":   300 * 1024,
+	"telkom":  300 * 1024,
+}
+
+// MMSSizeLimit returns the built in maximum MMS size in bytes for a
+// network, or DefaultMMSSizeLimit for unknown networks and "*". Use
+// APIConfig.MMSSizeLimits to override it
+func MMSSizeLimit(network string) int {
+	if limit, ok := mmsSizeLimits[strings.ToLower(network)]; ok {
+		return limit
+	}
+	return DefaultMMSSizeLimit
+}
+
+// MMSSizeLimit returns the maximum MMS size in bytes for a network, from
+// MMSSizeLimits or the built in limit
+func (config APIConfig) MMSSizeLimit(network string) int {
+	if limit, ok := config.MMSSizeLimits[strings.ToLower(network)]; ok && limit > 0 {
+		return limit
+	}
+	return MMSSizeLimit(network)
+}
+
+// normalizeMMSSizeLimits copies the limits with lowercase network keys
+func normalizeMMSSizeLimits(limits map[string]int) map[string]int {
+	if len(limits) == 0 {
+		return nil
+	}
+	normalized := make(map[string]int, len(limits))
+	for network, limit := range limits {
+		normalized[strings.ToLower(network)] = limit
+	}
+	return normalized
+}
+
+// MMSSizeReport is the estimated size of an MMS message
+type MMSSizeReport struct {
+	// Total is the estimated size of the message in bytes
+	Total int
+	// Limit is the size limit of the message's network in bytes
+	Limit int
+	// Overhead is the estimated envelope overhead included in Total
+	Overhead int
+	// Contents is the decoded size of every content item, largest first
+	Contents []MMSContentSize
+}
+
+// MMSContentSize is the decoded size of a slide content item
+type MMSContentSize struct {
+	// Slide is the index of the slide the content is on
+	Slide int
+	// Name of the content
+	Name string
+	// Type is one of MMSContentType*
+	Type string
+	// Size is the decoded size in bytes
+	Size int
+}
+
+// SizeReport estimates the size of the message as sent to the network:
+// the decoded media and text plus the envelope overhead, against the
+// built in limit of the network
+func (data SubmitMMSMessageData) SizeReport() MMSSizeReport {
+	return data.sizeReport(MMSSizeLimit(data.Network))
+}
+
+// MMSSizeReport estimates the size of the message against the network's
+// limit in the APIConfig
+func (api *MessagingAPI) MMSSizeReport(data SubmitMMSMessageData) MMSSizeReport {
+	return data.sizeReport(api.config.MMSSizeLimit(data.Network))
+}
+
+// sizeReport estimates the size of the message against limit
+func (data SubmitMMSMessageData) sizeReport(limit int) MMSSizeReport {
+	report := MMSSizeReport{
+		Limit:    limit,
+		Overhead: mmsMessageOverhead + len(data.Subject),
+	}
+
+	for i, slide := range data.Slides {
+		report.Overhead += mmsSlideOverhead
+		for _, content := range slide.Content {
+			report.Overhead += mmsContentOverhead + len(content.Name) + len(content.Mime)
+			report.Contents = append(report.Contents, MMSContentSize{
+				Slide: i,
+				Name:  content.Name,
+				Type:  content.Type,
+				Size:  content.DecodedSize(),
+			})
+		}
+	}
+
+	report.Total = report.Overhead
+	for _, content := range report.Contents {
+		report.Total += content.Size
+	}
+	sort.SliceStable(report.Contents, func(i, j int) bool {
+		return report.Contents[i].Size > report.Contents[j].Size
+	})
+	return report
+}
+
+// Exceeded returns true if the message is over its network's size limit
+func (report MMSSizeReport) Exceeded() bool {
+	return report.Total > report.Limit
+}
+
+// Largest returns the content that contributes most to the size
+func (report MMSSizeReport) Largest() (MMSContentSize, bool) {
+	if len(report.Contents) == 0 {
+		return MMSContentSize{}, false
+	}
+	return report.Contents[0], true
+}
+
+// DecodedSize returns the size of the content once decoded, text content
+// is not base64 encoded
+func (content MMSSlideContent) DecodedSize() int {
+	if content.Type == MMSContentTypeText {
+		return len(content.Data)
+	}
+
+	return base64DecodedSize(content.Data)
+}
+
+// base64DecodedSize returns the size of base64 data once decoded,
+// ignoring whitespace
+func base64DecodedSize(data string) int {
+	size := 0
+	padding := 0
+	for i := 0; i < len(data); i++ {
+		switch data[i] {
+		case '\r', '\n', ' ', '\t':
+		case '=':
+			padding++
+			size++
+		default:
+			size++
+		}
+	}
+	return size/4*3 + (size%4)*3/4 - padding
+}
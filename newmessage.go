@@ -2,10 +2,39 @@ package messagingapi
 
 import (
 	"errors"
+	"fmt"
 )
 
-// Validate checks that all required fields are set before submitting
+// prepare applies the options of the message data which change its
+// content, such as generating the Text of an email
+func (message *NewMessage) prepare() {
+	if data, ok := message.Data.(SubmitEmailMessageData); ok {
+		message.Data = data.prepare()
+	}
+}
+
+// Validate checks that all required fields are set before submitting,
+// using the built-in limits. Use MessagingAPI.Validate to check against
+// the limits of an APIConfig, as Create does
 func (message *NewMessage) Validate() error {
+	return message.validate(APIConfig{})
+}
+
+// Validate checks the message as Create does, against the limits of the
+// APIConfig
+func (api *MessagingAPI) Validate(message NewMessage) error {
+	message.prepare()
+	return message.validate(api.config)
+}
+
+// validate checks the message against the limits of the config
+func (message *NewMessage) validate(config APIConfig) error {
+	return message.validateFields(config, true)
+}
+
+// validateFields checks the message, the MMS size limit is only checked
+// when checkSize is true
+func (message *NewMessage) validateFields(config APIConfig, checkSize bool) error {
 	
 	var err error
 	if message.Action == 0 {
@@ -30,6 +59,11 @@ func (message *NewMessage) Validate() error {
 			if len(data.MSISDN) == 0  || len(data.MSISDN) > 1 {
 				err = errors.New("A message must have one recipient set in MSISDN")
 			}
+			if report := data.sizeReport(config.MMSSizeLimit(data.Network)); checkSize && report.Exceeded() {
+				largest, _ := report.Largest()
+				err = fmt.Errorf("MMS message is %d bytes, over the %d byte limit for network %s. The largest content is %s at %d bytes",
+					report.Total, report.Limit, data.Network, largest.Name, largest.Size)
+			}
 		}
 		
 	} else if message.Action == APIActionTypesSubmitSMS {
@@ -52,8 +86,8 @@ func (message *NewMessage) Validate() error {
 			if data.Network == "" {
 				err = errors.New("Network cannot be blank")
 			}
-			if len(data.Address) == 0  || len(data.Address) > 1 {
-				err = errors.New("Email messages must have at least one recipient listed in Address")
+			if recipientErr := data.validateRecipients(config.maxRecipients()); recipientErr != nil {
+				err = recipientErr
 			}
 			if data.HTML == "" && data.Text == "" {
 				err = errors.New("Email messages must have either HTML or Text set, or both")
@@ -61,6 +95,9 @@ func (message *NewMessage) Validate() error {
 			if data.Subject == "" {
 				err = errors.New("Email messages must have a subject")
 			}
+			if attachmentErr := data.validateAttachments(config.attachmentLimit()); attachmentErr != nil {
+				err = attachmentErr
+			}
 		}
 		
 	} 
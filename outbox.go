@@ -0,0 +1,499 @@
+package messagingapi
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Outbox record states
+const (
+	// The record is waiting to be submitted, or retried
+	OutboxStatePending = "pending"
+	// The API accepted the record and returned a MessageID
+	OutboxStateSent = "sent"
+	// The record was rejected or ran out of attempts
+	OutboxStateFailed = "failed"
+)
+
+// OutboxConfig is the configuration passed when opening an Outbox
+type OutboxConfig struct {
+	// Dir is the directory the outbox records are persisted in
+	Dir string
+	// MaxAttempts is the amount of submissions before a record fails,
+	// defaults to 10
+	MaxAttempts int
+	// RetryDelay is the delay before the first retry, doubled on every
+	// following attempt. Defaults to 30 seconds
+	RetryDelay time.Duration
+	// MaxRetryDelay caps the delay between retries, defaults to 15 minutes
+	MaxRetryDelay time.Duration
+	// PollInterval is how often the dispatcher checks for due records,
+	// defaults to 5 seconds
+	PollInterval time.Duration
+	// OnSent is called after a record was accepted by the API (optional)
+	OnSent func(record OutboxRecord)
+	// OnFailed is called after a record failed permanently (optional)
+	OnFailed func(record OutboxRecord)
+}
+
+// OutboxRecord is a message persisted in the outbox before submission
+type OutboxRecord struct {
+	// Key is the dedup key the record was enqueued with
+	Key string
+	// Route is the API route the record is submitted to
+	Route string
+	// Data is the packaged JSON submitted to the API
+	Data string
+	// IdempotencyKey is sent with every attempt so the API can discard
+	// duplicates, defaults to Key
+	IdempotencyKey string
+	// State is one of OutboxState*
+	State string
+	// Attempts is the amount of times submission was attempted
+	Attempts int
+	// MessageID is the ID returned by the API once sent
+	MessageID string
+	// LastError is the error of the last failed attempt
+	LastError string
+	// Created is when the record was enqueued
+	Created time.Time
+	// Updated is when the record was last changed
+	Updated time.Time
+	// NextAttempt is when the record is due to be submitted
+	NextAttempt time.Time
+}
+
+// Outbox persists messages to disk before they are submitted, so a
+// message survives a crash and is submitted at least once. Records are
+// identified by a dedup key, enqueueing the same key twice is a no-op
+type Outbox struct {
+	api     *MessagingAPI
+	config  OutboxConfig
+	lock    sync.Mutex
+	records map[string]*OutboxRecord
+	sending map[string]bool
+	running bool
+	wake    chan struct{}
+	stop    chan struct{}
+	done    chan struct{}
+}
+
+// NewOutbox opens the outbox in config.Dir, loading any records left
+// behind by a previous run so pending ones are submitted again
+func NewOutbox(api *MessagingAPI, config OutboxConfig) (*Outbox, error) {
+
+	if api == nil {
+		return nil, errors.New("API can not be nil")
+	}
+	if config.Dir == "" {
+		return nil, errors.New("Outbox directory can not be blank")
+	}
+	if config.MaxAttempts <= 0 {
+		config.MaxAttempts = 10
+	}
+	if config.RetryDelay <= 0 {
+		config.RetryDelay = 30 * time.Second
+	}
+	if config.MaxRetryDelay <= 0 {
+		config.MaxRetryDelay = 15 * time.Minute
+	}
+	if config.PollInterval <= 0 {
+		config.PollInterval = 5 * time.Second
+	}
+
+	err := os.MkdirAll(config.Dir, 0700)
+	if err != nil {
+		return nil, err
+	}
+
+	o := Outbox{
+		api:     api,
+		config:  config,
+		records: make(map[string]*OutboxRecord),
+		sending: make(map[string]bool),
+		wake:    make(chan struct{}, 1),
+	}
+
+	err = o.load()
+	if err != nil {
+		return nil, err
+	}
+
+	return &o, nil
+}
+
+// Enqueue validates the message and persists it for submission via Create
+func (o *Outbox) Enqueue(key string, message NewMessage) (OutboxRecord, error) {
+	message.prepare()
+	err := message.validate(o.api.config)
+	if err != nil {
+		return OutboxRecord{}, err
+	}
+
+	jsonBytes, err := json.Marshal(message)
+	if err != nil {
+		return OutboxRecord{}, err
+	}
+
+	return o.enqueue(key, "message/send", string(jsonBytes), message.IdempotencyKey)
+}
+
+// EnqueueBuild validates the build request and persists it for
+// submission via Generate
+func (o *Outbox) EnqueueBuild(key string, request BuildRequest) (OutboxRecord, error) {
+	err := request.Validate()
+	if err != nil {
+		return OutboxRecord{}, err
+	}
+
+	messageJson, err := request.Package()
+	if err != nil {
+		return OutboxRecord{}, err
+	}
+
+	return o.enqueue(key, "generate/video", messageJson, request.IdempotencyKey)
+}
+
+// enqueue persists a new record, or returns the existing record for key
+func (o *Outbox) enqueue(key string, route string, data string, idempotencyKey string) (OutboxRecord, error) {
+	if key == "" {
+		return OutboxRecord{}, errors.New("Outbox key can not be blank")
+	}
+	if idempotencyKey == "" {
+		idempotencyKey = key
+	}
+
+	o.lock.Lock()
+	defer o.lock.Unlock()
+
+	if existing, ok := o.records[key]; ok {
+		return *existing, nil
+	}
+
+	now := time.Now()
+	record := OutboxRecord{
+		Key:            key,
+		Route:          route,
+		Data:           data,
+		IdempotencyKey: idempotencyKey,
+		State:          OutboxStatePending,
+		Created:        now,
+		Updated:        now,
+		NextAttempt:    now,
+	}
+
+	// The record must be on disk before the caller can rely on it
+	err := o.save(record)
+	if err != nil {
+		return OutboxRecord{}, err
+	}
+	o.records[key] = &record
+	o.notify()
+
+	return record, nil
+}
+
+// Get returns the record enqueued with key
+func (o *Outbox) Get(key string) (OutboxRecord, bool) {
+	o.lock.Lock()
+	defer o.lock.Unlock()
+
+	record, ok := o.records[key]
+	if !ok {
+		return OutboxRecord{}, false
+	}
+	return *record, true
+}
+
+// Records returns all records in the given state, oldest first
+func (o *Outbox) Records(state string) []OutboxRecord {
+	o.lock.Lock()
+	defer o.lock.Unlock()
+
+	var records []OutboxRecord
+	for _, record := range o.records {
+		if record.State == state {
+			records = append(records, *record)
+		}
+	}
+	sort.Slice(records, func(i, j int) bool {
+		return records[i].Created.Before(records[j].Created)
+	})
+	return records
+}
+
+// Remove deletes a sent or failed record from the outbox. Removing a key
+// allows it to be enqueued again
+func (o *Outbox) Remove(key string) error {
+	o.lock.Lock()
+	defer o.lock.Unlock()
+
+	record, ok := o.records[key]
+	if !ok {
+		return nil
+	}
+	if record.State == OutboxStatePending {
+		return errors.New("Pending outbox records can not be removed")
+	}
+
+	err := os.Remove(o.path(key))
+	if err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	delete(o.records, key)
+	return nil
+}
+
+// Start runs the background dispatcher until Stop is called
+func (o *Outbox) Start() {
+	o.lock.Lock()
+	defer o.lock.Unlock()
+
+	if o.running {
+		return
+	}
+	o.running = true
+	o.stop = make(chan struct{})
+	o.done = make(chan struct{})
+	go o.run(o.stop, o.done)
+}
+
+// Stop stops the background dispatcher, waiting for the current
+// submission to complete
+func (o *Outbox) Stop() {
+	o.lock.Lock()
+	if !o.running {
+		o.lock.Unlock()
+		return
+	}
+	o.running = false
+	close(o.stop)
+	done := o.done
+	o.lock.Unlock()
+
+	<-done
+}
+
+// run is the dispatcher loop
+func (o *Outbox) run(stop chan struct{}, done chan struct{}) {
+	defer close(done)
+
+	ticker := time.NewTicker(o.config.PollInterval)
+	defer ticker.Stop()
+
+	for {
+		o.drain(stop)
+		select {
+		case <-stop:
+			return
+		case <-ticker.C:
+		case <-o.wake:
+		}
+	}
+}
+
+// Drain submits every record that is due once, and returns the amount of
+// records still pending afterwards
+func (o *Outbox) Drain() int {
+	return o.drain(nil)
+}
+
+// drain submits due records until done or stop is closed
+func (o *Outbox) drain(stop chan struct{}) int {
+	for _, key := range o.due() {
+		select {
+		case <-stop:
+			return len(o.Records(OutboxStatePending))
+		default:
+		}
+		o.dispatch(key)
+	}
+	return len(o.Records(OutboxStatePending))
+}
+
+// due returns the keys of pending records whose next attempt has passed
+func (o *Outbox) due() []string {
+	now := time.Now()
+	var records []OutboxRecord
+	for _, record := range o.Records(OutboxStatePending) {
+		if !record.NextAttempt.After(now) {
+			records = append(records, record)
+		}
+	}
+
+	keys := make([]string, len(records))
+	for i, record := range records {
+		keys[i] = record.Key
+	}
+	return keys
+}
+
+// dispatch submits a single record and persists the outcome
+func (o *Outbox) dispatch(key string) {
+	o.lock.Lock()
+	current, ok := o.records[key]
+	if !ok || current.State != OutboxStatePending || o.sending[key] {
+		o.lock.Unlock()
+		return
+	}
+	// Drain may run alongside the dispatcher, only one may submit a record
+	o.sending[key] = true
+	record := *current
+	o.lock.Unlock()
+
+	result, statusCode, err := o.api.submitMessage(record.Route, record.Data, record.IdempotencyKey)
+
+	record.Attempts++
+	record.Updated = time.Now()
+	if err == nil && result.StatusCode == APIResultStatusesOk && result.MessageResult.MessageID != "" {
+		record.State = OutboxStateSent
+		record.MessageID = result.MessageResult.MessageID
+		record.LastError = ""
+	} else {
+		if err != nil {
+			record.LastError = err.Error()
+		} else if result.StatusCode == APIResultStatusesOk {
+			record.LastError = "No MessageID returned by API"
+		} else {
+			record.LastError = result.StatusDescription
+		}
+
+		// Requests rejected by an open breaker are retried once it closes
+		rejected := errors.Is(err, ErrCircuitOpen)
+		if (err != nil && !rejected) || !retryableStatus(statusCode) || record.Attempts >= o.config.MaxAttempts {
+			record.State = OutboxStateFailed
+		} else {
+			record.NextAttempt = record.Updated.Add(o.retryDelay(record.Attempts))
+		}
+	}
+
+	o.lock.Lock()
+	saveErr := o.save(record)
+	if saveErr != nil {
+		// The outcome is kept in memory, but a sent record will be
+		// submitted again after a restart
+		record.LastError = fmt.Sprintf("Unable to persist outbox record: %s", saveErr.Error())
+	}
+	o.records[key] = &record
+	delete(o.sending, key)
+	o.lock.Unlock()
+
+	if record.State == OutboxStateSent && o.config.OnSent != nil {
+		o.config.OnSent(record)
+	} else if record.State == OutboxStateFailed && o.config.OnFailed != nil {
+		o.config.OnFailed(record)
+	}
+}
+
+// retryDelay returns the backoff delay after the given amount of attempts
+func (o *Outbox) retryDelay(attempts int) time.Duration {
+	delay := o.config.RetryDelay
+	for i := 1; i < attempts; i++ {
+		delay *= 2
+		if delay >= o.config.MaxRetryDelay {
+			return o.config.MaxRetryDelay
+		}
+	}
+	return delay
+}
+
+// notify wakes the dispatcher if it is waiting
+func (o *Outbox) notify() {
+	select {
+	case o.wake <- struct{}{}:
+	default:
+	}
+}
+
+// path returns the file a record is persisted in
+func (o *Outbox) path(key string) string {
+	hash := sha256.Sum256([]byte(key))
+	return filepath.Join(o.config.Dir, hex.EncodeToString(hash[:])+".json")
+}
+
+// save atomically writes the record to disk
+func (o *Outbox) save(record OutboxRecord) error {
+	jsonBytes, err := json.Marshal(record)
+	if err != nil {
+		return err
+	}
+
+	return writeFileAtomic(o.config.Dir, o.path(record.Key), jsonBytes)
+}
+
+// writeFileAtomic writes data to path in dir via a temporary file, so a
+// crash never leaves a partially written file behind
+func writeFileAtomic(dir string, path string, data []byte) error {
+	file, err := ioutil.TempFile(dir, "record-*.tmp")
+	if err != nil {
+		return err
+	}
+	tmpName := file.Name()
+
+	_, err = file.Write(data)
+	if err == nil {
+		err = file.Sync()
+	}
+	closeErr := file.Close()
+	if err == nil {
+		err = closeErr
+	}
+	if err == nil {
+		err = os.Rename(tmpName, path)
+	}
+	if err != nil {
+		os.Remove(tmpName)
+		return err
+	}
+
+	// Sync the directory so the rename survives a crash
+	dirFile, err := os.Open(dir)
+	if err != nil {
+		return err
+	}
+	defer dirFile.Close()
+	dirFile.Sync()
+	return nil
+}
+
+// load reads all persisted records, removing temporary files left behind
+// by an interrupted save
+func (o *Outbox) load() error {
+	files, err := ioutil.ReadDir(o.config.Dir)
+	if err != nil {
+		return err
+	}
+
+	for _, file := range files {
+		name := filepath.Join(o.config.Dir, file.Name())
+		if strings.HasSuffix(file.Name(), ".tmp") {
+			os.Remove(name)
+			continue
+		}
+		if file.IsDir() || !strings.HasSuffix(file.Name(), ".json") {
+			continue
+		}
+
+		jsonBytes, err := ioutil.ReadFile(name)
+		if err != nil {
+			return err
+		}
+		var record OutboxRecord
+		err = json.Unmarshal(jsonBytes, &record)
+		if err != nil {
+			return fmt.Errorf("Unable to read outbox record %s: %s", file.Name(), err.Error())
+		}
+		o.records[record.Key] = &record
+	}
+	return nil
+}
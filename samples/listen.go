@@ -0,0 +1,287 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	messagingapi "github.com/iliveit/go-messaging-client"
+)
+
+// Postback kinds printed by the listener
+const (
+	PostbackKindStatus   = "status"
+	PostbackKindSMS      = "sms"
+	PostbackKindEmail    = "email"
+	PostbackKindApproval = "approval"
+)
+
+// Listener receives postbacks from the API and prints every event it
+// receives, optionally forwarding the raw postback to another URL
+type Listener struct {
+	// JSONL prints one JSON object per line instead of pretty printing
+	JSONL bool
+	// Forward is a base URL the raw postbacks are also POSTed to (optional)
+	Forward string
+
+	lock   sync.Mutex
+	client *http.Client
+}
+
+// ListenerEvent is a single line written by the listener in JSONL mode
+type ListenerEvent struct {
+	Received time.Time   `json:"received"`
+	Kind     string      `json:"kind"`
+	Path     string      `json:"path"`
+	Event    interface{} `json:"event"`
+}
+
+// RunListener parses the listen command arguments and serves the
+// status, reply and approval postback endpoints until the server fails
+func RunListener(args []string) error {
+	flags := flag.NewFlagSet("listen", flag.ContinueOnError)
+	port := flags.Int("port", 9001, "Port to listen on")
+	jsonl := flags.Bool("jsonl", false, "Log every postback as a single JSON line")
+	forward := flags.String("forward", "", "Base URL to forward postbacks to (optional)")
+	if err := flags.Parse(args); err != nil {
+		return err
+	}
+
+	if *port <= 0 || *port > 65535 {
+		return errors.New("Port must be between 1 and 65535")
+	}
+
+	listener := &Listener{
+		JSONL:   *jsonl,
+		Forward: strings.TrimRight(*forward, "/"),
+		client:  &http.Client{Timeout: 10 * time.Second},
+	}
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("/status", listener.HandleStatusUpdates)
+	mux.HandleFunc("/sms", listener.HandleIncomingSMS)
+	mux.HandleFunc("/email", listener.HandleIncomingEmail)
+	mux.HandleFunc("/approval", listener.HandleApprovalEvents)
+	// PostbackReplyUrl may point at the root, so detect the reply type
+	mux.HandleFunc("/", listener.HandleIncomingReply)
+
+	addr := ":" + strconv.Itoa(*port)
+	fmt.Fprintf(os.Stderr, "Listening for postbacks on %s\n", addr)
+	return http.ListenAndServe(addr, mux)
+}
+
+// HandleStatusUpdates receives POSTs from the API for status updates
+func (l *Listener) HandleStatusUpdates(w http.ResponseWriter, r *http.Request) {
+	body, ok := l.readBody(w, r)
+	if !ok {
+		return
+	}
+
+	var status messagingapi.StatusResult
+	if err := json.Unmarshal(body, &status); err != nil {
+		l.reject(w, r, err)
+		return
+	}
+	l.accept(w, r, PostbackKindStatus, status, body)
+}
+
+// HandleIncomingSMS receives POSTs from the API for incoming SMS
+func (l *Listener) HandleIncomingSMS(w http.ResponseWriter, r *http.Request) {
+	body, ok := l.readBody(w, r)
+	if !ok {
+		return
+	}
+
+	var incoming messagingapi.IncomingSMS
+	if err := json.Unmarshal(body, &incoming); err != nil {
+		l.reject(w, r, err)
+		return
+	}
+	l.accept(w, r, PostbackKindSMS, incoming, body)
+}
+
+// HandleIncomingEmail receives POSTs from the API for email replies
+func (l *Listener) HandleIncomingEmail(w http.ResponseWriter, r *http.Request) {
+	body, ok := l.readBody(w, r)
+	if !ok {
+		return
+	}
+
+	var incoming messagingapi.IncomingEmail
+	if err := json.Unmarshal(body, &incoming); err != nil {
+		l.reject(w, r, err)
+		return
+	}
+	l.accept(w, r, PostbackKindEmail, summarizeEmail(incoming), body)
+}
+
+// HandleApprovalEvents receives POSTs from the API for approver responses
+// and approval batch state changes
+func (l *Listener) HandleApprovalEvents(w http.ResponseWriter, r *http.Request) {
+	body, ok := l.readBody(w, r)
+	if !ok {
+		return
+	}
+
+	event, err := messagingapi.ParseApprovalEvent(body)
+	if err != nil {
+		l.reject(w, r, err)
+		return
+	}
+	l.accept(w, r, PostbackKindApproval, event, body)
+}
+
+// EmailReplySummary is printed for email replies instead of their raw content
+type EmailReplySummary struct {
+	MessageId   string   `json:"message_id"`
+	Address     string   `json:"address"`
+	From        string   `json:"from"`
+	Subject     string   `json:"subject"`
+	Date        string   `json:"date"`
+	NewContent  string   `json:"new_content"`
+	Attachments []string `json:"attachments,omitempty"`
+}
+
+// summarizeEmail parses the email reply, returning it as is if it can't
+// be parsed
+func summarizeEmail(incoming messagingapi.IncomingEmail) interface{} {
+	parsed, err := incoming.Parse()
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "Unable to parse email reply: "+err.Error())
+		return incoming
+	}
+
+	summary := EmailReplySummary{
+		MessageId:  parsed.OriginalMessageID,
+		Address:    incoming.Address,
+		Subject:    parsed.Subject,
+		NewContent: parsed.NewContent,
+	}
+	if parsed.From != nil {
+		summary.From = parsed.From.Address
+	}
+	if !parsed.Date.IsZero() {
+		summary.Date = parsed.Date.Format(time.RFC3339)
+	}
+	for _, attachment := range parsed.Attachments {
+		summary.Attachments = append(summary.Attachments, fmt.Sprintf("%s (%s, %d bytes)", attachment.Filename, attachment.ContentType, attachment.Size()))
+	}
+	return summary
+}
+
+// HandleIncomingReply receives replies on a shared URL, email replies
+// are recognised by their raw content field
+func (l *Listener) HandleIncomingReply(w http.ResponseWriter, r *http.Request) {
+	body, ok := l.readBody(w, r)
+	if !ok {
+		return
+	}
+
+	var fields map[string]json.RawMessage
+	if err := json.Unmarshal(body, &fields); err != nil {
+		l.reject(w, r, err)
+		return
+	}
+
+	if _, isEmail := fields["content"]; isEmail {
+		var incoming messagingapi.IncomingEmail
+		if err := json.Unmarshal(body, &incoming); err != nil {
+			l.reject(w, r, err)
+			return
+		}
+		l.accept(w, r, PostbackKindEmail, summarizeEmail(incoming), body)
+		return
+	}
+
+	var incoming messagingapi.IncomingSMS
+	if err := json.Unmarshal(body, &incoming); err != nil {
+		l.reject(w, r, err)
+		return
+	}
+	l.accept(w, r, PostbackKindSMS, incoming, body)
+}
+
+// readBody reads the POST body, responding with an error when it can't
+func (l *Listener) readBody(w http.ResponseWriter, r *http.Request) ([]byte, bool) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return nil, false
+	}
+	body, err := ioutil.ReadAll(r.Body)
+	if err != nil {
+		l.reject(w, r, err)
+		return nil, false
+	}
+	return body, true
+}
+
+// reject logs the failed postback and responds with a bad request
+func (l *Listener) reject(w http.ResponseWriter, r *http.Request, err error) {
+	fmt.Fprintf(os.Stderr, "Unable to get POST body on %s: %s\n", r.URL.Path, err.Error())
+	http.Error(w, "Invalid body", http.StatusBadRequest)
+}
+
+// accept prints and forwards the event before acknowledging it
+func (l *Listener) accept(w http.ResponseWriter, r *http.Request, kind string, event interface{}, body []byte) {
+	l.print(ListenerEvent{
+		Received: time.Now(),
+		Kind:     kind,
+		Path:     r.URL.Path,
+		Event:    event,
+	})
+
+	if l.Forward != "" {
+		l.forward(r.URL.Path, body)
+	}
+
+	// You must respond with a status code of 200 otherwise the API will keep retrying
+	// the message to this endpoint
+	w.Write([]byte("Ok"))
+}
+
+// print writes the event to stdout, either pretty printed or as JSONL
+func (l *Listener) print(event ListenerEvent) {
+	l.lock.Lock()
+	defer l.lock.Unlock()
+
+	if l.JSONL {
+		byteString, err := json.Marshal(event)
+		if err != nil {
+			fmt.Fprintln(os.Stderr, "Unable to marshal event: "+err.Error())
+			return
+		}
+		fmt.Println(string(byteString))
+		return
+	}
+
+	byteString, err := json.MarshalIndent(event.Event, "", " ")
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "Unable to marshal event: "+err.Error())
+		return
+	}
+	fmt.Printf("[%s] %s postback on %s\n", event.Received.Format(time.RFC3339), event.Kind, event.Path)
+	fmt.Println(string(byteString))
+	fmt.Println()
+}
+
+// forward POSTs the raw postback to the same path on the forward URL
+func (l *Listener) forward(path string, body []byte) {
+	resp, err := l.client.Post(l.Forward+path, "application/json", bytes.NewReader(body))
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "Unable to forward postback: "+err.Error())
+		return
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		fmt.Fprintf(os.Stderr, "Forward to %s returned %d\n", l.Forward+path, resp.StatusCode)
+	}
+}
@@ -0,0 +1,243 @@
+package messagingapi
+
+import (
+	"encoding/json"
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// ScrubErrorCode is the reason a scrub reports an MSISDN can't be
+// messaged normally
+type ScrubErrorCode string
+
+// Scrub error codes
+const (
+	// The scrub succeeded
+	ScrubErrorNone ScrubErrorCode = ""
+	// The number was ported to another network
+	ScrubErrorPorted ScrubErrorCode = "ported"
+	// The number isn't allocated to a subscriber
+	ScrubErrorUnknownNumber ScrubErrorCode = "unknown_number"
+	// The number isn't a valid MSISDN
+	ScrubErrorInvalidNumber ScrubErrorCode = "invalid_number"
+	// The subscriber is switched off or out of coverage
+	ScrubErrorAbsentSubscriber ScrubErrorCode = "absent_subscriber"
+	// The subscriber is barred from receiving messages
+	ScrubErrorBarred ScrubErrorCode = "barred"
+	// The network couldn't be queried
+	ScrubErrorUnavailable ScrubErrorCode = "unavailable"
+	// Any other error, the Error text has the details
+	ScrubErrorOther ScrubErrorCode = "other"
+)
+
+// scrubErrorAliases maps the legacy free text forms of error codes, after
+// normalizing, to the enumerated codes
+var scrubErrorAliases = map[string]ScrubErrorCode{
+	"ok":                  ScrubErrorNone,
+	"none":                ScrubErrorNone,
+	"0":                   ScrubErrorNone,
+	"ported":              ScrubErrorPorted,
+	"number_ported":       ScrubErrorPorted,
+	"ported_number":       ScrubErrorPorted,
+	"mnp":                 ScrubErrorPorted,
+	"unknown_number":      ScrubErrorUnknownNumber,
+	"unknown":             ScrubErrorUnknownNumber,
+	"unknown_subscriber":  ScrubErrorUnknownNumber,
+	"unallocated":         ScrubErrorUnknownNumber,
+	"number_not_found":    ScrubErrorUnknownNumber,
+	"not_found":           ScrubErrorUnknownNumber,
+	"invalid_number":      ScrubErrorInvalidNumber,
+	"invalid":             ScrubErrorInvalidNumber,
+	"invalid_msisdn":      ScrubErrorInvalidNumber,
+	"absent_subscriber":   ScrubErrorAbsentSubscriber,
+	"absent":              ScrubErrorAbsentSubscriber,
+	"subscriber_absent":   ScrubErrorAbsentSubscriber,
+	"barred":              ScrubErrorBarred,
+	"subscriber_barred":   ScrubErrorBarred,
+	"call_barred":         ScrubErrorBarred,
+	"unavailable":         ScrubErrorUnavailable,
+	"network_unavailable": ScrubErrorUnavailable,
+	"timeout":             ScrubErrorUnavailable,
+	"other":               ScrubErrorOther,
+}
+
+// ParseScrubErrorCode returns the code for an error code as sent by the
+// API, accepting legacy free text forms such as "Number Ported". Codes
+// that aren't recognised are ScrubErrorOther
+func ParseScrubErrorCode(value string) ScrubErrorCode {
+	normalized := strings.ToLower(strings.TrimSpace(value))
+	normalized = strings.NewReplacer(" ", "_", "-", "_").Replace(normalized)
+	if normalized == "" {
+		return ScrubErrorNone
+	}
+	if code, ok := scrubErrorAliases[normalized]; ok {
+		return code
+	}
+	return ScrubErrorOther
+}
+
+// UnmarshalJSON decodes the code from a string or a number
+func (code *ScrubErrorCode) UnmarshalJSON(data []byte) error {
+	value, err := jsonScalar(data)
+	if err != nil {
+		return fmt.Errorf("Invalid scrub error code %s", string(data))
+	}
+	*code = ParseScrubErrorCode(value)
+	return nil
+}
+
+// ScrubResult is the handset information of an MSISDN
+type ScrubResult struct {
+	Network      string `json:"network"`
+	MSISDN       string `json:"msisdn"`
+	HandsetMake  string `json:"handset_make"`
+	HandsetModel string `json:"handset_model"`
+	// AllowSend is false if messages may not be sent to the MSISDN
+	AllowSend  bool           `json:"allow_send"`
+	ScreenSize ScreenSizeObj  `json:"screen_size"`
+	ErrorCode  ScrubErrorCode `json:"error_code"`
+	// Error describes the ErrorCode
+	Error string `json:"error"`
+}
+
+// UnmarshalJSON decodes the result, accepting AllowSend as a bool, number
+// or string, and under the legacy AllowSend key
+func (result *ScrubResult) UnmarshalJSON(data []byte) error {
+	type plain ScrubResult
+	var decoded struct {
+		plain
+		AllowSend       json.RawMessage `json:"allow_send"`
+		LegacyAllowSend json.RawMessage `json:"AllowSend"`
+	}
+	err := json.Unmarshal(data, &decoded)
+	if err != nil {
+		return err
+	}
+
+	*result = ScrubResult(decoded.plain)
+	allowSend := decoded.AllowSend
+	if len(allowSend) == 0 {
+		allowSend = decoded.LegacyAllowSend
+	}
+	if len(allowSend) == 0 {
+		return nil
+	}
+	result.AllowSend, err = parseJSONBool(allowSend)
+	if err != nil {
+		return fmt.Errorf("Invalid allow_send %s", string(allowSend))
+	}
+	return nil
+}
+
+// IsPorted returns true if the number was ported to another network
+func (result ScrubResult) IsPorted() bool {
+	return result.ErrorCode == ScrubErrorPorted
+}
+
+// IsUnknownNumber returns true if the number isn't allocated to a
+// subscriber or isn't a valid MSISDN
+func (result ScrubResult) IsUnknownNumber() bool {
+	return result.ErrorCode == ScrubErrorUnknownNumber || result.ErrorCode == ScrubErrorInvalidNumber
+}
+
+// IsAbsent returns true if the subscriber is switched off or out of coverage
+func (result ScrubResult) IsAbsent() bool {
+	return result.ErrorCode == ScrubErrorAbsentSubscriber
+}
+
+// IsBarred returns true if the subscriber may not receive messages
+func (result ScrubResult) IsBarred() bool {
+	return result.ErrorCode == ScrubErrorBarred
+}
+
+// IsTransient returns true if the error may be resolved by scrubbing
+// again later, such as an absent subscriber or unavailable network
+func (result ScrubResult) IsTransient() bool {
+	return result.ErrorCode == ScrubErrorAbsentSubscriber || result.ErrorCode == ScrubErrorUnavailable
+}
+
+// ScreenSizeObj is the screen size of a handset in pixels, zero if unknown
+type ScreenSizeObj struct {
+	Width  int `json:"width"`
+	Height int `json:"height"`
+}
+
+// UnmarshalJSON decodes the dimensions from numbers or strings such as
+// "240" and "240px". Blank and unparsable dimensions are zero
+func (screen *ScreenSizeObj) UnmarshalJSON(data []byte) error {
+	var decoded struct {
+		Width  json.RawMessage `json:"width"`
+		Height json.RawMessage `json:"height"`
+	}
+	err := json.Unmarshal(data, &decoded)
+	if err != nil {
+		return err
+	}
+	screen.Width = parseJSONDimension(decoded.Width)
+	screen.Height = parseJSONDimension(decoded.Height)
+	return nil
+}
+
+// Known returns true if both dimensions are known
+func (screen ScreenSizeObj) Known() bool {
+	return screen.Width > 0 && screen.Height > 0
+}
+
+// String returns the size as WIDTHxHEIGHT
+func (screen ScreenSizeObj) String() string {
+	return strconv.Itoa(screen.Width) + "x" + strconv.Itoa(screen.Height)
+}
+
+// jsonScalar returns a JSON string, number or bool as a string, blank for null
+func jsonScalar(data []byte) (string, error) {
+	var value interface{}
+	err := json.Unmarshal(data, &value)
+	if err != nil {
+		return "", err
+	}
+	switch v := value.(type) {
+	case nil:
+		return "", nil
+	case string:
+		return v, nil
+	case float64:
+		return strconv.FormatFloat(v, 'f', -1, 64), nil
+	case bool:
+		return strconv.FormatBool(v), nil
+	}
+	return "", fmt.Errorf("Expected a JSON scalar, got %s", string(data))
+}
+
+// parseJSONBool decodes a bool sent as a bool, number or string such as
+// "yes". Null and blank are false
+func parseJSONBool(data []byte) (bool, error) {
+	value, err := jsonScalar(data)
+	if err != nil {
+		return false, err
+	}
+	switch strings.ToLower(strings.TrimSpace(value)) {
+	case "true", "1", "yes", "y":
+		return true, nil
+	case "false", "0", "no", "n", "":
+		return false, nil
+	}
+	return false, fmt.Errorf("Invalid bool %s", value)
+}
+
+// parseJSONDimension decodes a pixel dimension sent as a number or string
+func parseJSONDimension(data []byte) int {
+	if len(data) == 0 {
+		return 0
+	}
+	value, err := jsonScalar(data)
+	if err != nil {
+		return 0
+	}
+	value = strings.TrimSuffix(strings.ToLower(strings.TrimSpace(value)), "px")
+	number, err := strconv.ParseFloat(strings.TrimSpace(value), 64)
+	if err != nil || number < 0 {
+		return 0
+	}
+	return int(number)
+}
@@ -0,0 +1,304 @@
+package messagingapi
+
+import (
+	"container/list"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+)
+
+// ScrubStore persists scrub results beyond the in-memory cache, for
+// example so they survive a restart or are shared between processes
+type ScrubStore interface {
+	// Load returns the stored result and when it was scrubbed, ok is
+	// false if the MSISDN isn't stored
+	Load(msisdn string) (result ScrubResult, scrubbed time.Time, ok bool, err error)
+	// Save stores the result and when it was scrubbed
+	Save(msisdn string, result ScrubResult, scrubbed time.Time) error
+	// Delete removes the stored result, if any
+	Delete(msisdn string) error
+}
+
+// ScrubCacheConfig is the configuration passed to NewScrubCache
+type ScrubCacheConfig struct {
+	// Size is the maximum amount of results kept in memory, the least
+	// recently used are evicted first. Defaults to 10000
+	Size int
+	// TTL is how long a result is used before scrubbing again, defaults
+	// to 1 hour
+	TTL time.Duration
+	// TransientTTL is how long a result with a transient error, such as
+	// an absent subscriber or unavailable network, is used before
+	// scrubbing again. Defaults to 5 minutes, negative disables caching
+	// transient results
+	TransientTTL time.Duration
+	// Store persists results behind the in-memory cache (optional)
+	Store ScrubStore
+}
+
+// ScrubCache caches the results of GetMSISDNScrub in memory, with an
+// optional persistent ScrubStore behind it. It is safe for concurrent use
+type ScrubCache struct {
+	lock    sync.Mutex
+	config  ScrubCacheConfig
+	entries map[string]*list.Element
+	order   *list.List
+}
+
+// scrubCacheEntry is a cached scrub result
+type scrubCacheEntry struct {
+	msisdn   string
+	result   ScrubResult
+	scrubbed time.Time
+}
+
+// NewScrubCache creates an empty cache
+func NewScrubCache(config ScrubCacheConfig) *ScrubCache {
+	if config.Size <= 0 {
+		config.Size = 10000
+	}
+	if config.TTL <= 0 {
+		config.TTL = time.Hour
+	}
+	if config.TransientTTL == 0 {
+		config.TransientTTL = 5 * time.Minute
+	}
+	return &ScrubCache{
+		config:  config,
+		entries: make(map[string]*list.Element),
+		order:   list.New(),
+	}
+}
+
+// Get returns the cached result for the MSISDN if it hasn't expired. A
+// result found in the store is added to the in-memory cache. Store errors
+// are treated as a miss
+func (c *ScrubCache) Get(msisdn string) (ScrubResult, bool) {
+	c.lock.Lock()
+	if element, ok := c.entries[msisdn]; ok {
+		entry := element.Value.(*scrubCacheEntry)
+		if c.fresh(entry.result, entry.scrubbed) {
+			c.order.MoveToFront(element)
+			c.lock.Unlock()
+			return entry.result, true
+		}
+		c.remove(element)
+	}
+	c.lock.Unlock()
+
+	if c.config.Store == nil {
+		return ScrubResult{}, false
+	}
+	result, scrubbed, ok, err := c.config.Store.Load(msisdn)
+	if err != nil || !ok || !c.fresh(result, scrubbed) {
+		return ScrubResult{}, false
+	}
+
+	c.lock.Lock()
+	c.add(msisdn, result, scrubbed)
+	c.lock.Unlock()
+	return result, true
+}
+
+// Put caches the result for the MSISDN, saving it to the store if set.
+// Transient results aren't cached when TransientTTL is negative
+func (c *ScrubCache) Put(msisdn string, result ScrubResult) error {
+	if c.ttl(result) <= 0 {
+		return nil
+	}
+	scrubbed := time.Now()
+
+	c.lock.Lock()
+	c.add(msisdn, result, scrubbed)
+	c.lock.Unlock()
+
+	if c.config.Store == nil {
+		return nil
+	}
+	return c.config.Store.Save(msisdn, result, scrubbed)
+}
+
+// Remove drops the MSISDN from the cache and the store, so the next scrub
+// goes to the API
+func (c *ScrubCache) Remove(msisdn string) error {
+	c.lock.Lock()
+	if element, ok := c.entries[msisdn]; ok {
+		c.remove(element)
+	}
+	c.lock.Unlock()
+
+	if c.config.Store == nil {
+		return nil
+	}
+	return c.config.Store.Delete(msisdn)
+}
+
+// Len returns the amount of results in memory, including expired ones
+// which haven't been evicted yet
+func (c *ScrubCache) Len() int {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+
+	return c.order.Len()
+}
+
+// ttl returns how long the result is used for, transient errors may be
+// resolved by scrubbing again shortly
+func (c *ScrubCache) ttl(result ScrubResult) time.Duration {
+	if result.IsTransient() {
+		return c.config.TransientTTL
+	}
+	return c.config.TTL
+}
+
+// fresh reports whether a result scrubbed at the time can still be used
+func (c *ScrubCache) fresh(result ScrubResult, scrubbed time.Time) bool {
+	return time.Since(scrubbed) < c.ttl(result)
+}
+
+// add inserts or replaces the entry, evicting the least recently used
+// entries when the cache is full
+func (c *ScrubCache) add(msisdn string, result ScrubResult, scrubbed time.Time) {
+	if element, ok := c.entries[msisdn]; ok {
+		entry := element.Value.(*scrubCacheEntry)
+		entry.result = result
+		entry.scrubbed = scrubbed
+		c.order.MoveToFront(element)
+		return
+	}
+
+	c.entries[msisdn] = c.order.PushFront(&scrubCacheEntry{
+		msisdn:   msisdn,
+		result:   result,
+		scrubbed: scrubbed,
+	})
+	for c.order.Len() > c.config.Size {
+		c.remove(c.order.Back())
+	}
+}
+
+// remove drops the entry from memory
+func (c *ScrubCache) remove(element *list.Element) {
+	c.order.Remove(element)
+	delete(c.entries, element.Value.(*scrubCacheEntry).msisdn)
+}
+
+// FileScrubStore is a ScrubStore keeping every result in a JSON file in
+// a directory
+type FileScrubStore struct {
+	dir string
+}
+
+// fileScrubRecord is the content of a FileScrubStore file
+type fileScrubRecord struct {
+	MSISDN   string
+	Result   ScrubResult
+	Scrubbed time.Time
+}
+
+// NewFileScrubStore creates a store in dir, creating the directory if
+// it doesn't exist
+func NewFileScrubStore(dir string) (*FileScrubStore, error) {
+	if dir == "" {
+		return nil, errors.New("Scrub store directory must not be blank")
+	}
+	err := os.MkdirAll(dir, 0700)
+	if err != nil {
+		return nil, err
+	}
+	return &FileScrubStore{dir: dir}, nil
+}
+
+// Load reads the stored result of the MSISDN
+func (s *FileScrubStore) Load(msisdn string) (ScrubResult, time.Time, bool, error) {
+	jsonBytes, err := ioutil.ReadFile(s.path(msisdn))
+	if os.IsNotExist(err) {
+		return ScrubResult{}, time.Time{}, false, nil
+	}
+	if err != nil {
+		return ScrubResult{}, time.Time{}, false, err
+	}
+
+	var record fileScrubRecord
+	err = json.Unmarshal(jsonBytes, &record)
+	if err != nil {
+		return ScrubResult{}, time.Time{}, false, err
+	}
+	return record.Result, record.Scrubbed, true, nil
+}
+
+// Save atomically writes the result of the MSISDN
+func (s *FileScrubStore) Save(msisdn string, result ScrubResult, scrubbed time.Time) error {
+	jsonBytes, err := json.Marshal(fileScrubRecord{
+		MSISDN:   msisdn,
+		Result:   result,
+		Scrubbed: scrubbed,
+	})
+	if err != nil {
+		return err
+	}
+	return writeFileAtomic(s.dir, s.path(msisdn), jsonBytes)
+}
+
+// Delete removes the stored result of the MSISDN
+func (s *FileScrubStore) Delete(msisdn string) error {
+	err := os.Remove(s.path(msisdn))
+	if os.IsNotExist(err) {
+		return nil
+	}
+	return err
+}
+
+// path returns the file the MSISDN is stored in
+func (s *FileScrubStore) path(msisdn string) string {
+	hash := sha256.Sum256([]byte(msisdn))
+	return filepath.Join(s.dir, hex.EncodeToString(hash[:])+".json")
+}
+
+// BulkScrubResult is the outcome of scrubbing one MSISDN with ScrubMany
+type BulkScrubResult struct {
+	Result APIResult
+	Err    error
+}
+
+// ScrubMany scrubs every MSISDN with at most concurrency requests in
+// flight, using the ScrubCache when configured. Duplicate MSISDNs are
+// scrubbed once. Concurrency defaults to 4
+func (api *MessagingAPI) ScrubMany(msisdns []string, concurrency int) map[string]BulkScrubResult {
+	if concurrency <= 0 {
+		concurrency = 4
+	}
+
+	results := make(map[string]BulkScrubResult, len(msisdns))
+	seen := make(map[string]bool, len(msisdns))
+	var lock sync.Mutex
+	var wg sync.WaitGroup
+	slots := make(chan struct{}, concurrency)
+
+	for _, msisdn := range msisdns {
+		if seen[msisdn] {
+			continue
+		}
+		seen[msisdn] = true
+
+		wg.Add(1)
+		slots <- struct{}{}
+		go func(msisdn string) {
+			defer wg.Done()
+			defer func() { <-slots }()
+
+			result, err := api.GetMSISDNScrub(msisdn)
+			lock.Lock()
+			results[msisdn] = BulkScrubResult{Result: result, Err: err}
+			lock.Unlock()
+		}(msisdn)
+	}
+	wg.Wait()
+	return results
+}
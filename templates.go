@@ -0,0 +1,265 @@
+package messagingapi
+
+import (
+	"bytes"
+	"errors"
+	htmltemplate "html/template"
+	"strings"
+	texttemplate "text/template"
+	"unicode/utf16"
+)
+
+// SMS encodings reported by AnalyzeSMS
+const (
+	SMSEncodingGSM7 = "GSM-7"
+	SMSEncodingUCS2 = "UCS-2"
+)
+
+// TemplateConfig configures how templates are parsed
+type TemplateConfig struct {
+	// Funcs are extra functions available to the templates (optional)
+	Funcs map[string]interface{}
+}
+
+// SMSTemplate renders SubmitSMSMessageData.Message from per-recipient data
+// using text/template. Missing map keys are errors
+type SMSTemplate struct {
+	message *texttemplate.Template
+}
+
+// NewSMSTemplate parses the template of an SMS message
+func NewSMSTemplate(message string, config TemplateConfig) (*SMSTemplate, error) {
+	if message == "" {
+		return nil, errors.New("SMS template can not be blank")
+	}
+	parsed, err := newTextTemplate("message", message, config)
+	if err != nil {
+		return nil, err
+	}
+	return &SMSTemplate{message: parsed}, nil
+}
+
+// Render returns base with Message rendered from data
+func (t *SMSTemplate) Render(data interface{}, base SubmitSMSMessageData) (SubmitSMSMessageData, error) {
+	message, err := executeTextTemplate(t.message, data)
+	if err != nil {
+		return base, err
+	}
+	if strings.TrimSpace(message) == "" {
+		return base, errors.New("SMS template rendered a blank message")
+	}
+	base.Message = message
+	return base, nil
+}
+
+// Preview renders the message for data and reports how it will be sent
+func (t *SMSTemplate) Preview(data interface{}) (SMSInfo, error) {
+	rendered, err := t.Render(data, SubmitSMSMessageData{})
+	if err != nil {
+		return SMSInfo{}, err
+	}
+	return AnalyzeSMS(rendered.Message), nil
+}
+
+// EmailTemplateSource holds the templates of an email. Subject and Text
+// use text/template, HTML uses html/template so data is escaped
+type EmailTemplateSource struct {
+	Subject string
+	HTML    string
+	Text    string
+}
+
+// EmailTemplate renders the Subject, HTML and Text of an email from
+// per-recipient data. Missing map keys are errors
+type EmailTemplate struct {
+	subject *texttemplate.Template
+	html    *htmltemplate.Template
+	text    *texttemplate.Template
+}
+
+// NewEmailTemplate parses the templates of an email. Subject and at least
+// one of HTML and Text are required
+func NewEmailTemplate(source EmailTemplateSource, config TemplateConfig) (*EmailTemplate, error) {
+	if source.Subject == "" {
+		return nil, errors.New("Email template must have a subject")
+	}
+	if source.HTML == "" && source.Text == "" {
+		return nil, errors.New("Email template must have either HTML or Text set, or both")
+	}
+
+	var t EmailTemplate
+	var err error
+	t.subject, err = newTextTemplate("subject", source.Subject, config)
+	if err != nil {
+		return nil, err
+	}
+	if source.Text != "" {
+		t.text, err = newTextTemplate("text", source.Text, config)
+		if err != nil {
+			return nil, err
+		}
+	}
+	if source.HTML != "" {
+		t.html, err = htmltemplate.New("html").Option("missingkey=error").Funcs(config.Funcs).Parse(source.HTML)
+		if err != nil {
+			return nil, err
+		}
+	}
+	return &t, nil
+}
+
+// Render returns base with the Subject, HTML and Text rendered from data.
+// Fields without a template keep their value from base
+func (t *EmailTemplate) Render(data interface{}, base SubmitEmailMessageData) (SubmitEmailMessageData, error) {
+	subject, err := executeTextTemplate(t.subject, data)
+	if err != nil {
+		return base, err
+	}
+	// Headers can't contain line breaks
+	base.Subject = strings.Join(strings.Fields(subject), " ")
+
+	if t.text != nil {
+		base.Text, err = executeTextTemplate(t.text, data)
+		if err != nil {
+			return base, err
+		}
+	}
+	if t.html != nil {
+		var out bytes.Buffer
+		err = t.html.Execute(&out, data)
+		if err != nil {
+			return base, err
+		}
+		base.HTML = out.String()
+	}
+	return base, nil
+}
+
+// Preview renders the email for data and reports its content and size as
+// it will be sent, including generated Text and inlined CSS
+func (t *EmailTemplate) Preview(data interface{}, base SubmitEmailMessageData) (EmailInfo, error) {
+	rendered, err := t.Render(data, base)
+	if err != nil {
+		return EmailInfo{}, err
+	}
+	return AnalyzeEmail(rendered), nil
+}
+
+// newTextTemplate parses a text/template that fails on missing keys
+func newTextTemplate(name string, source string, config TemplateConfig) (*texttemplate.Template, error) {
+	return texttemplate.New(name).Option("missingkey=error").Funcs(config.Funcs).Parse(source)
+}
+
+// executeTextTemplate renders the template to a string
+func executeTextTemplate(t *texttemplate.Template, data interface{}) (string, error) {
+	var out bytes.Buffer
+	err := t.Execute(&out, data)
+	return out.String(), err
+}
+
+// SMSInfo describes how an SMS message will be sent
+type SMSInfo struct {
+	Message string
+	// Encoding is SMSEncodingGSM7, or SMSEncodingUCS2 when the message has
+	// characters GSM-7 can't encode
+	Encoding string
+	// Length is the length in GSM-7 septets or UTF-16 code units
+	Length int
+	// Segments is the amount of SMS messages the message is split into
+	Segments int
+	// NonGSMCharacters are the characters which force UCS-2 encoding
+	NonGSMCharacters []string
+}
+
+// gsm7Basic is the GSM 03.38 basic character set
+const gsm7Basic = "@£$¥èéùìòÇ\nØø\rÅåΔ_ΦΓΛΩΠΨΣΘΞ\x1bÆæßÉ !\"#¤%&'()*+,-./0123456789:;<=>?" +
+	"¡ABCDEFGHIJKLMNOPQRSTUVWXYZÄÖÑÜ§¿abcdefghijklmnopqrstuvwxyzäöñüà"
+
+// gsm7Extension is the GSM 03.38 extension table, these characters take
+// two septets
+const gsm7Extension = "\f^{}\\[~]|€"
+
+// AnalyzeSMS reports the encoding, length and segment count of an SMS.
+// A single GSM-7 message holds 160 septets, or 153 per segment when split.
+// A single UCS-2 message holds 70 UTF-16 code units, or 67 per segment.
+// Characters are never split across segments
+func AnalyzeSMS(message string) SMSInfo {
+	info := SMSInfo{Message: message, Encoding: SMSEncodingGSM7}
+
+	var sizes []int
+	seen := make(map[rune]bool)
+	for _, r := range message {
+		switch {
+		case strings.ContainsRune(gsm7Basic, r):
+			sizes = append(sizes, 1)
+		case strings.ContainsRune(gsm7Extension, r):
+			sizes = append(sizes, 2)
+		default:
+			info.Encoding = SMSEncodingUCS2
+			if !seen[r] {
+				seen[r] = true
+				info.NonGSMCharacters = append(info.NonGSMCharacters, string(r))
+			}
+		}
+	}
+
+	single, segment := 160, 153
+	if info.Encoding == SMSEncodingUCS2 {
+		single, segment = 70, 67
+		sizes = sizes[:0]
+		for _, r := range message {
+			sizes = append(sizes, len(utf16.Encode([]rune{r})))
+		}
+	}
+
+	for _, size := range sizes {
+		info.Length += size
+	}
+	if info.Length <= single {
+		info.Segments = 1
+		if info.Length == 0 {
+			info.Segments = 0
+		}
+		return info
+	}
+
+	info.Segments = 1
+	used := 0
+	for _, size := range sizes {
+		if used+size > segment {
+			info.Segments++
+			used = 0
+		}
+		used += size
+	}
+	return info
+}
+
+// EmailInfo describes an email as it will be sent
+type EmailInfo struct {
+	Subject string
+	HTML    string
+	Text    string
+	// Size is the size in bytes of the subject, bodies and base64 encoded
+	// attachments
+	Size int
+	// AttachmentSize is the decoded size in bytes of the attachments
+	AttachmentSize int
+}
+
+// AnalyzeEmail reports the content and size of an email as it will be
+// sent, after generating Text and inlining CSS when enabled
+func AnalyzeEmail(data SubmitEmailMessageData) EmailInfo {
+	data = data.prepare()
+	info := EmailInfo{
+		Subject:        data.Subject,
+		HTML:           data.HTML,
+		Text:           data.Text,
+		Size:           len(data.Subject) + len(data.HTML) + len(data.Text),
+		AttachmentSize: data.AttachmentSize(),
+	}
+	for _, attachment := range data.Attachments {
+		info.Size += len(attachment.Data)
+	}
+	return info
+}
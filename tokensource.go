@@ -0,0 +1,167 @@
+package messagingapi
+
+import (
+	"errors"
+	"io/ioutil"
+	"os"
+	"strings"
+	"sync"
+	"time"
+)
+
+// TokenSource supplies the access token, it is consulted for every request
+// so rotated tokens are picked up without rebuilding the client
+type TokenSource interface {
+	// Token returns the current access token
+	Token() (string, error)
+}
+
+// TokenRefresher is implemented by a TokenSource that can reload its token.
+// When the API rejects a token with a 401, Refresh is called and the
+// request is retried once
+type TokenRefresher interface {
+	// Refresh reloads and returns the access token
+	Refresh() (string, error)
+}
+
+// staticTokenSource always returns the same token
+type staticTokenSource string
+
+// NewStaticTokenSource returns a TokenSource for a fixed access token
+func NewStaticTokenSource(token string) TokenSource {
+	return staticTokenSource(token)
+}
+
+// Token returns the fixed access token
+func (s staticTokenSource) Token() (string, error) {
+	if s == "" {
+		return "", errors.New("Access Token can not be blank")
+	}
+	return string(s), nil
+}
+
+// envTokenSource reads the token from an environment variable
+type envTokenSource string
+
+// NewEnvTokenSource returns a TokenSource reading the access token from
+// the named environment variable on every request
+func NewEnvTokenSource(name string) TokenSource {
+	return envTokenSource(name)
+}
+
+// Token returns the value of the environment variable
+func (s envTokenSource) Token() (string, error) {
+	token := strings.TrimSpace(os.Getenv(string(s)))
+	if token == "" {
+		return "", errors.New("Environment variable " + string(s) + " is blank")
+	}
+	return token, nil
+}
+
+// Refresh reads the environment variable again
+func (s envTokenSource) Refresh() (string, error) {
+	return s.Token()
+}
+
+// FileTokenSource reads the access token from a file, reloading it when
+// the file changes. Surrounding whitespace in the file is ignored
+type FileTokenSource struct {
+	path     string
+	lock     sync.RWMutex
+	token    string
+	modTime  time.Time
+	size     int64
+	stop     chan struct{}
+	stopOnce sync.Once
+}
+
+// NewFileTokenSource reads the token from path and checks the file for
+// changes every interval until Close is called. An interval of zero
+// defaults to 10 seconds
+func NewFileTokenSource(path string, interval time.Duration) (*FileTokenSource, error) {
+
+	if path == "" {
+		return nil, errors.New("Token file path can not be blank")
+	}
+	if interval <= 0 {
+		interval = 10 * time.Second
+	}
+
+	s := FileTokenSource{
+		path: path,
+		stop: make(chan struct{}),
+	}
+
+	_, err := s.Refresh()
+	if err != nil {
+		return nil, err
+	}
+
+	go s.watch(interval)
+
+	return &s, nil
+}
+
+// Token returns the last token read from the file
+func (s *FileTokenSource) Token() (string, error) {
+	s.lock.RLock()
+	defer s.lock.RUnlock()
+	return s.token, nil
+}
+
+// Refresh reads the token from the file now
+func (s *FileTokenSource) Refresh() (string, error) {
+	info, err := os.Stat(s.path)
+	if err != nil {
+		return "", err
+	}
+
+	content, err := ioutil.ReadFile(s.path)
+	if err != nil {
+		return "", err
+	}
+	token := strings.TrimSpace(string(content))
+	if token == "" {
+		return "", errors.New("Token file " + s.path + " is blank")
+	}
+
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	s.token = token
+	s.modTime = info.ModTime()
+	s.size = info.Size()
+	return token, nil
+}
+
+// Close stops watching the file for changes
+func (s *FileTokenSource) Close() {
+	s.stopOnce.Do(func() {
+		close(s.stop)
+	})
+}
+
+// watch reloads the token whenever the file's size or modification time
+// changes. A file that can't be read keeps the previous token
+func (s *FileTokenSource) watch(interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-s.stop:
+			return
+		case <-ticker.C:
+		}
+
+		info, err := os.Stat(s.path)
+		if err != nil {
+			continue
+		}
+		s.lock.RLock()
+		changed := !info.ModTime().Equal(s.modTime) || info.Size() != s.size
+		s.lock.RUnlock()
+		if changed {
+			s.Refresh()
+		}
+	}
+}